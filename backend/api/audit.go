@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samirettali/webmonitor/auth"
+	"github.com/samirettali/webmonitor/events"
+	"github.com/samirettali/webmonitor/models"
+)
+
+const (
+	auditCheck   = "check"
+	auditChannel = "channel"
+)
+
+// audit adds a mutation performed by the user making the request to the
+// audit log. A nil before or after means the entity was created or
+// deleted. It runs with the context of the transaction of the mutation,
+// see Storage.Atomic, so that a mutation is only saved with its entry.
+func (h *StorageHandler) audit(ctx context.Context, r *http.Request, orgID string, action string, entityType string, entityID string, before interface{}, after interface{}) error {
+	user, _ := auth.UserFromContext(r.Context())
+
+	entry := models.AuditEntry{
+		OrgID:      orgID,
+		ActorID:    user.ID,
+		ActorEmail: user.Email,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Date:       time.Now(),
+	}
+
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+	}
+	if err == nil && after != nil {
+		entry.After, err = json.Marshal(after)
+	}
+	if err == nil {
+		err = h.Storage.AddAuditEntry(ctx, &entry)
+	}
+	return errors.Wrapf(err, "can't audit %s %s %s", action, entityType, entityID)
+}
+
+// checkEvent is the data of the events about mutations of checks. Check is
+// missing when it was deleted.
+type checkEvent struct {
+	Action string      `json:"action"`
+	Check  interface{} `json:"check,omitempty"`
+}
+
+// publishCheck publishes a mutation of a check, once it is saved.
+func (h *StorageHandler) publishCheck(orgID string, action string, checkID string, before interface{}, after interface{}) {
+	check, _ := after.(*models.Check)
+	if check == nil {
+		check, _ = before.(*models.Check)
+	}
+	var tags []string
+	if check != nil {
+		tags = check.Tags
+	}
+
+	eventType := events.CheckUpdated
+	switch action {
+	case "create":
+		eventType = events.CheckCreated
+	case "delete":
+		eventType = events.CheckDeleted
+	}
+
+	h.Events.Publish(events.Event{
+		Type:    eventType,
+		OrgID:   orgID,
+		CheckID: checkID,
+		Tags:    tags,
+		Data:    checkEvent{Action: action, Check: after},
+	})
+}
+
+// checkUpdateAction names an update of a check, telling pauses and resumes
+// apart from other changes.
+func checkUpdateAction(before *models.Check, after *models.Check) string {
+	switch {
+	case before.Active && !after.Active:
+		return "pause"
+	case !before.Active && after.Active:
+		return "resume"
+	default:
+		return "update"
+	}
+}
+
+// GetAuditLog lists the mutations of the organisation from the newest one,
+// optionally restricted to a check and an actor.
+func (h *StorageHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	limit, err := pageSize(r)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		EntityID: query.Get("check"),
+		Actor:    query.Get("actor"),
+		Limit:    limit + 1,
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		filter.BeforeSeq, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			problem(w, r, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	entries, err := h.Storage.GetAuditLog(r.Context(), orgID, filter)
+	if err != nil {
+		h.Logger.Errorf("get audit log: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	var next string
+	if len(entries) > limit {
+		entries = entries[:limit]
+		next = strconv.FormatInt(entries[limit-1].Seq, 10)
+	}
+
+	if len(entries) == 0 {
+		entries = make([]models.AuditEntry, 0)
+	}
+
+	h.writePage(w, &entries, next)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/models"
+)
+
+// uptimeWindows are the periods GetUptime reports the uptime over.
+var uptimeWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+type uptimeWindow struct {
+	Probes int `json:"probes"`
+	// Percent is the share of the probes that found the page up, null
+	// when there are none.
+	Percent *float64 `json:"percent"`
+}
+
+type uptimeResponse struct {
+	// Up is the state found by the latest probe, null if the check was
+	// never probed.
+	Up        *bool                   `json:"up"`
+	LastProbe *models.Probe           `json:"last_probe"`
+	Uptime    map[string]uptimeWindow `json:"uptime"`
+}
+
+// GetUptime returns the current state of an availability check and the
+// share of its probes that found it up over the last 24 hours, 7 days and
+// 30 days.
+func (h *StorageHandler) GetUptime(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	_, err := h.Storage.GetCheck(r.Context(), orgID, id)
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("get check: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	resp := uptimeResponse{Uptime: make(map[string]uptimeWindow, len(uptimeWindows))}
+
+	probe, err := h.Storage.GetLatestProbe(r.Context(), id)
+	if err != nil && err != sql.ErrNoRows {
+		h.Logger.Errorf("get latest probe: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if err == nil {
+		resp.Up = &probe.Up
+		resp.LastProbe = &probe
+	}
+
+	now := time.Now()
+	for _, window := range uptimeWindows {
+		uptime, err := h.Storage.GetUptime(r.Context(), orgID, id, now.Add(-window.duration))
+		if err != nil {
+			h.Logger.Errorf("get uptime: %v", err)
+			problem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+
+		result := uptimeWindow{Probes: uptime.Probes}
+		if uptime.Probes > 0 {
+			percent := math.Round(float64(uptime.Up)/float64(uptime.Probes)*1e5) / 1e3
+			result.Percent = &percent
+		}
+		resp.Uptime[window.name] = result
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// GetProbes lists the probes of an availability check, newest first,
+// optionally restricted to [from, to).
+func (h *StorageHandler) GetProbes(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var filter models.ProbeFilter
+	var err error
+
+	if filter.Limit, err = pageSize(r); err != nil {
+		problem(w, r, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	if filter.From, err = timeParam(r, "from"); err != nil {
+		problem(w, r, http.StatusBadRequest, "Invalid from")
+		return
+	}
+
+	if filter.To, err = timeParam(r, "to"); err != nil {
+		problem(w, r, http.StatusBadRequest, "Invalid to")
+		return
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var date string
+		date, filter.AfterID, err = decodeCursor(cursor)
+		if err == nil {
+			filter.AfterDate, err = time.Parse(time.RFC3339Nano, date)
+		}
+		if err != nil {
+			problem(w, r, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	probes, err := h.Storage.GetProbes(r.Context(), orgID, mux.Vars(r)["id"], filter)
+	if err != nil {
+		h.Logger.Errorf("get probes: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	var next string
+	if len(probes) > limit {
+		probes = probes[:limit]
+		last := probes[limit-1]
+		next = encodeCursor(last.Date.Format(time.RFC3339Nano), last.ID)
+	}
+
+	if len(probes) == 0 {
+		probes = make([]models.Probe, 0)
+	}
+
+	h.writePage(w, &probes, next)
+}
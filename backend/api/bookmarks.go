@@ -0,0 +1,422 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/samirettali/webmonitor/auth"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/utils"
+	"golang.org/x/net/html"
+)
+
+const (
+	// defaultBookmarkInterval is the interval of imported bookmarks when the
+	// request doesn't set one.
+	defaultBookmarkInterval = 600
+	// bookmarkFetches is how many bookmarks are fetched at the same time.
+	bookmarkFetches = 8
+)
+
+// bookmark is a URL found in an OPML or bookmark file. Tags are the names of
+// the folders containing it, outermost first.
+type bookmark struct {
+	Name string
+	URL  string
+	Tags []string
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	URL      string        `xml:"url,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlDocument struct {
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+// readOPML returns the outlines with a URL. Feeds are monitored through their
+// xmlUrl, other outlines through their htmlUrl or url.
+func readOPML(body []byte) ([]bookmark, error) {
+	var doc opmlDocument
+	err := xml.Unmarshal(body, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookmarks []bookmark
+	var walk func(outlines []opmlOutline, folders []string)
+	walk = func(outlines []opmlOutline, folders []string) {
+		for _, outline := range outlines {
+			name := outline.Text
+			if name == "" {
+				name = outline.Title
+			}
+
+			for _, link := range []string{outline.XMLURL, outline.HTMLURL, outline.URL} {
+				if link != "" {
+					bookmarks = append(bookmarks, bookmark{Name: name, URL: link, Tags: folders})
+					break
+				}
+			}
+
+			if len(outline.Outlines) > 0 {
+				walk(outline.Outlines, appendFolder(folders, name))
+			}
+		}
+	}
+	walk(doc.Outlines, nil)
+
+	return bookmarks, nil
+}
+
+// readNetscape returns the links of a bookmark file in the Netscape format
+// exported by browsers, where folders are an H3 heading followed by a DL
+// list of their content.
+func readNetscape(body []byte) ([]bookmark, error) {
+	var bookmarks []bookmark
+	var folders []string
+	// lists holds, for every open DL, whether it is the content of a folder.
+	var lists []bool
+	var heading, link *strings.Builder
+	var href, folder string
+	pending := false
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return bookmarks, nil
+			}
+			return nil, z.Err()
+		case html.StartTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "h3":
+				heading = &strings.Builder{}
+			case "a":
+				href = ""
+				for _, attr := range tok.Attr {
+					if attr.Key == "href" {
+						href = attr.Val
+					}
+				}
+				link = &strings.Builder{}
+			case "dl":
+				lists = append(lists, pending)
+				if pending {
+					folders = appendFolder(folders, folder)
+				}
+				pending = false
+			}
+		case html.EndTagToken:
+			switch z.Token().Data {
+			case "h3":
+				if heading != nil {
+					folder, pending = heading.String(), true
+					heading = nil
+				}
+			case "a":
+				if link != nil && href != "" {
+					bookmarks = append(bookmarks, bookmark{Name: link.String(), URL: href, Tags: folders})
+				}
+				link = nil
+			case "dl":
+				if n := len(lists); n > 0 {
+					if lists[n-1] && len(folders) > 0 {
+						folders = folders[:len(folders)-1]
+					}
+					lists = lists[:n-1]
+				}
+			}
+		case html.TextToken:
+			if heading != nil {
+				heading.Write(z.Text())
+			}
+			if link != nil {
+				link.Write(z.Text())
+			}
+		}
+	}
+}
+
+// appendFolder returns a copy of folders with name added, so that bookmarks
+// don't share the backing array of their tags.
+func appendFolder(folders []string, name string) []string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return folders
+	}
+	path := make([]string, len(folders), len(folders)+1)
+	copy(path, folders)
+	return append(path, name)
+}
+
+func bookmarkFormat(r *http.Request, body []byte) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		head := strings.ToLower(string(body[:min(len(body), 512)]))
+		switch {
+		case strings.Contains(head, "<opml"):
+			format = "opml"
+		case strings.Contains(head, "netscape-bookmark-file"):
+			format = "netscape"
+		}
+	}
+	return format, format == "opml" || format == "netscape"
+}
+
+// bookmarkCheck turns a bookmark into a check. Names are trimmed to the
+// length allowed for checks, falling back to the host of the URL, and the
+// folders are deduplicated.
+func bookmarkCheck(b bookmark, orgID string, interval uint64, email string) models.Check {
+	name := []rune(strings.Join(strings.Fields(b.Name), " "))
+	if len(name) < 3 {
+		if u, err := url.Parse(b.URL); err == nil && u.Host != "" {
+			name = []rune(u.Host)
+		}
+	}
+	if len(name) > 30 {
+		name = []rune(strings.TrimSpace(string(name[:30])))
+	}
+
+	tags := make([]string, 0, len(b.Tags))
+	for _, tag := range b.Tags {
+		if runes := []rune(tag); len(runes) > 50 {
+			tag = strings.TrimSpace(string(runes[:50]))
+		}
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	return models.Check{
+		OrgID:    orgID,
+		Name:     string(name),
+		URL:      strings.TrimSpace(b.URL),
+		Interval: interval,
+		Email:    email,
+		Active:   true,
+		Channels: make([]string, 0),
+		Tags:     tags,
+	}
+}
+
+// ImportBookmarks creates a check for every URL of an OPML outline or of a
+// bookmark file exported by a browser, tagged with the folders containing
+// it. Every URL is fetched as when creating a single check and the ones that
+// can't be reached are reported as failed, while URLs that are already
+// monitored are skipped. The other checks are created in one transaction.
+func (h *StorageHandler) ImportBookmarks(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+
+	interval := uint64(defaultBookmarkInterval)
+	if raw := query.Get("interval"); raw != "" {
+		var err error
+		interval, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil || interval == 0 {
+			problem(w, r, http.StatusBadRequest, "The interval must be a positive number")
+			return
+		}
+	}
+
+	email := query.Get("email")
+	if email == "" {
+		if user, ok := auth.UserFromContext(r.Context()); ok {
+			email = user.Email
+		}
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		h.Logger.Error("read: ", err)
+		problem(w, r, http.StatusBadRequest, fmt.Sprintf("The file could not be read: %v", err))
+		return
+	}
+
+	format, ok := bookmarkFormat(r, body)
+	if !ok {
+		problem(w, r, http.StatusBadRequest, "The file must be an OPML outline or a Netscape bookmark file")
+		return
+	}
+
+	var bookmarks []bookmark
+	if format == "opml" {
+		bookmarks, err = readOPML(body)
+	} else {
+		bookmarks, err = readNetscape(body)
+	}
+	if err != nil {
+		h.Logger.Error("decode: ", err)
+		problem(w, r, http.StatusBadRequest, fmt.Sprintf("The file is not valid %s: %v", format, err))
+		return
+	}
+
+	existing, err := h.Storage.GetChecks(r.Context(), models.CheckFilter{OrgID: orgID})
+	if err != nil {
+		h.Logger.Errorf("get checks: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	monitored := make(map[string]bool, len(existing))
+	for _, check := range existing {
+		monitored[check.URL] = true
+	}
+
+	v := newValidator()
+	checks := make([]models.Check, len(bookmarks))
+	report := importReport{DryRun: dryRun, Rows: make([]importResult, len(bookmarks))}
+	for i, b := range bookmarks {
+		checks[i] = bookmarkCheck(b, orgID, interval, email)
+		result := importResult{Row: i + 1, Name: checks[i].Name, Action: importCreate}
+
+		if monitored[checks[i].URL] {
+			result.Action = importSkip
+		} else if err := v.Struct(checks[i]); err != nil {
+			result.Action = importError
+			result.Errors = fieldErrors(err)
+		}
+		monitored[checks[i].URL] = true
+
+		report.Rows[i] = result
+	}
+
+	// The URLs are fetched bookmarkFetches at a time, each within the
+	// time allowed to the preview of a new check.
+	pending := 0
+	for _, result := range report.Rows {
+		if result.Action == importCreate {
+			pending++
+		}
+	}
+	if pending > 0 {
+		waves := (pending + bookmarkFetches - 1) / bookmarkFetches
+		extendWriteDeadline(w, time.Duration(waves)*previewPolicy(&checks[0]).Budget())
+	}
+	bodies := h.fetchBookmarks(r.Context(), checks, report.Rows)
+
+	var created []int
+	for i := range checks {
+		result := &report.Rows[i]
+		switch result.Action {
+		case importCreate:
+			report.Created++
+			created = append(created, i)
+		case importSkip:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+	}
+	if dryRun {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&report)
+		return
+	}
+
+	// The checks are created in one transaction, and the events are only
+	// published once it is committed.
+	var row int
+	err = h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		for _, i := range created {
+			result := &report.Rows[i]
+			check := &checks[i]
+			check.ID = uuid.NewString()
+			result.ID = check.ID
+			row = result.Row
+
+			err := h.Storage.CreateCheck(ctx, check)
+			if err != nil {
+				return err
+			}
+			status := models.Status{
+				ID:      uuid.NewString(),
+				Content: bodies[i],
+				CheckID: check.ID,
+				Date:    time.Now(),
+			}
+			err = h.Storage.UpdateStatus(ctx, check.ID, &status)
+			if err != nil {
+				return errors.Wrap(err, "can't add status")
+			}
+			err = h.audit(ctx, r, orgID, "create", auditCheck, check.ID, nil, check)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.Logger.Errorf("import bookmark %d: %v", row, err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	for _, i := range created {
+		h.publishCheck(orgID, "create", checks[i].ID, nil, &checks[i])
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&report)
+}
+
+// fetchBookmarks requests the URL of every check about to be created and
+// marks the ones that can't be reached as failed. It returns the bodies of
+// the pages, which become the first status of the checks.
+func (h *StorageHandler) fetchBookmarks(ctx context.Context, checks []models.Check, results []importResult) []string {
+	bodies := make([]string, len(checks))
+	sem := make(chan struct{}, bookmarkFetches)
+	var wg sync.WaitGroup
+
+	for i := range checks {
+		if results[i].Action != importCreate {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			resp, err := utils.Fetch(ctx, checks[i].URL, checks[i].Headers, previewPolicy(&checks[i]))
+			if err != nil {
+				h.Logger.Debugf("fetch %s: %v", checks[i].URL, err)
+				results[i].Action = importError
+				results[i].Errors = []FieldError{{Field: "url", Rule: "reachable"}}
+				return
+			}
+			bodies[i] = resp.Body
+		}(i)
+	}
+
+	wg.Wait()
+	return bodies
+}
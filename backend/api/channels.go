@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/storage"
+)
+
+func (h *StorageHandler) GetChannels(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	channels, err := h.Storage.GetChannels(r.Context(), orgID)
+	if err != nil {
+		h.Logger.Errorf("get channels: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	if len(channels) == 0 {
+		channels = make([]models.Channel, 0)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&channels)
+}
+
+func (h *StorageHandler) GetChannel(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	channel, err := h.Storage.GetChannel(r.Context(), orgID, mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The channel does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("get channel: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&channel)
+}
+
+func (h *StorageHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var channel models.Channel
+	if !h.decodeProblem(w, r, &channel) {
+		return
+	}
+
+	channel.ID = uuid.NewString()
+	channel.OrgID = orgID
+
+	err := h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		err := h.Storage.CreateChannel(ctx, &channel)
+		if err != nil {
+			return err
+		}
+		return h.audit(ctx, r, orgID, "create", auditChannel, channel.ID, nil, &channel)
+	})
+	if err == storage.ErrDuplicate {
+		problem(w, r, http.StatusConflict, "The key is already used by another channel", FieldError{Field: "key", Rule: "unique"})
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("create channel: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&channel)
+}
+
+func (h *StorageHandler) UpdateChannel(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var upd models.ChannelUpdate
+	var channel models.Channel
+	if !h.decodeProblem(w, r, &upd) {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	err := h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		before, err := h.Storage.GetChannel(ctx, orgID, id)
+		if err != nil {
+			return err
+		}
+		channel, err = h.Storage.UpdateChannel(ctx, orgID, id, &upd)
+		if err != nil {
+			return err
+		}
+		return h.audit(ctx, r, orgID, "update", auditChannel, id, &before, &channel)
+	})
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The channel does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("update channel: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&channel)
+}
+
+func (h *StorageHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	err := h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		before, err := h.Storage.GetChannel(ctx, orgID, id)
+		if err != nil {
+			return err
+		}
+		err = h.Storage.DeleteChannel(ctx, orgID, id)
+		if err != nil {
+			return err
+		}
+		return h.audit(ctx, r, orgID, "delete", auditChannel, id, &before, nil)
+	})
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The channel does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("delete channel: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/samirettali/webmonitor/auth"
+	"github.com/samirettali/webmonitor/events"
+	"github.com/samirettali/webmonitor/models"
+)
+
+// heartbeatInterval is how often a comment is sent on idle event streams so
+// that proxies and the server keep them open.
+const heartbeatInterval = 10 * time.Second
+
+// userOrgs returns the set of organisations of a user, whose events it can
+// receive. They are read once per stream, a user removed from an
+// organisation stops receiving its events when reconnecting.
+func (h *StorageHandler) userOrgs(r *http.Request, user *models.User) (map[string]bool, error) {
+	memberships, err := h.Storage.GetMemberships(r.Context(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+	orgs := make(map[string]bool, len(memberships))
+	for _, membership := range memberships {
+		orgs[membership.OrgID] = true
+	}
+	return orgs, nil
+}
+
+// StreamEvents streams the events of the organisations of the user as
+// Server-Sent Events, optionally restricted to the checks listed with the
+// check query parameter. A client reconnecting with Last-Event-ID first
+// receives the events it missed, or a reset event if they are no longer
+// buffered. Clients that can't set the header can use the last_event_id
+// query parameter.
+func (h *StorageHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusUnauthorized, "The request is not authenticated")
+		return
+	}
+
+	if h.Events == nil {
+		problem(w, r, http.StatusServiceUnavailable, "Events are not enabled")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.Logger.Errorf("events: %T can't flush", w)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	var after uint64
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" {
+		var err error
+		after, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			problem(w, r, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+	}
+
+	orgs, err := h.userOrgs(r, user)
+	if err != nil {
+		h.Logger.Errorf("get memberships: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	var checks map[string]bool
+	if ids := r.URL.Query()["check"]; len(ids) > 0 {
+		checks = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			checks[id] = true
+		}
+	}
+
+	sub, missed := h.Events.Subscribe(after)
+	defer h.Events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// The stream outlives the write timeout of the server, which is pushed
+	// back before every write.
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(2 * heartbeatInterval))
+		_, err := fmt.Fprintf(w, format, args...)
+		if err == nil {
+			flusher.Flush()
+		}
+		return err == nil
+	}
+
+	send := func(e *events.Event) bool {
+		if e.Type != events.Reset && (!orgs[e.OrgID] || checks != nil && !checks[e.CheckID]) {
+			return true
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			h.Logger.Errorf("encode event %d: %v", e.ID, err)
+			return true
+		}
+		return write("id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	}
+
+	if !write("retry: %d\n\n", (3 * time.Second).Milliseconds()) {
+		return
+	}
+	for i := range missed {
+		if !send(&missed[i]) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			// A closed subscription fell behind, the client resumes from
+			// the buffer when it reconnects.
+			if !ok || !send(&e) {
+				return
+			}
+		case <-heartbeat.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+// checkFilter builds a CheckFilter from the query string of r. The
+// organisation is left for the caller to set.
+func checkFilter(r *http.Request) (models.CheckFilter, error) {
+	query := r.URL.Query()
+	filter := models.CheckFilter{
+		Search:  query.Get("q"),
+		Tag:     query.Get("tag"),
+		GroupID: query.Get("group"),
+		Sort:    query.Get("sort"),
+	}
+
+	if raw := query.Get("active"); raw != "" {
+		active, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid active parameter")
+		}
+		filter.Active = &active
+	}
+
+	if raw := query.Get("failing"); raw != "" {
+		failing, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid failing parameter")
+		}
+		filter.Failing = &failing
+	}
+
+	if raw := query.Get("interval"); raw != "" {
+		interval, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid interval parameter")
+		}
+		filter.Interval = interval
+	}
+
+	var err error
+	if filter.ChangedAfter, err = timeParam(r, "changed_after"); err != nil {
+		return filter, fmt.Errorf("invalid changed_after parameter")
+	}
+
+	if filter.ChangedBefore, err = timeParam(r, "changed_before"); err != nil {
+		return filter, fmt.Errorf("invalid changed_before parameter")
+	}
+
+	if filter.Sort != "" && !validSort(filter.Sort) {
+		return filter, fmt.Errorf("invalid sort parameter, must be one of %s", strings.Join(models.CheckSortFields, ", "))
+	}
+
+	if query.Get("limit") != "" {
+		if filter.Limit, err = pageSize(r); err != nil {
+			return filter, fmt.Errorf("invalid limit parameter")
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		if filter.AfterValue, filter.AfterID, err = decodeCursor(cursor); err != nil {
+			return filter, fmt.Errorf("invalid cursor parameter")
+		}
+	}
+
+	return filter, nil
+}
+
+func validSort(sort string) bool {
+	field := strings.TrimPrefix(sort, "-")
+	for _, f := range models.CheckSortFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSortValue returns the value check is sorted by for the given sort,
+// formatted the way the storage compares it.
+func checkSortValue(check *models.Check, sort string) string {
+	switch strings.TrimPrefix(sort, "-") {
+	case "url":
+		return check.URL
+	case "interval":
+		return strconv.FormatUint(check.Interval, 10)
+	case "last_changed":
+		if check.LastChanged == nil {
+			return time.Unix(0, 0).UTC().Format("2006-01-02T15:04:05.999999")
+		}
+		return check.LastChanged.Format("2006-01-02T15:04:05.999999")
+	default:
+		return check.Name
+	}
+}
@@ -1,39 +1,62 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"io"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/samirettali/webmonitor/apitypes"
+	"github.com/samirettali/webmonitor/events"
+	"github.com/samirettali/webmonitor/extract"
 	"github.com/samirettali/webmonitor/logger"
 	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/monitor"
 	"github.com/samirettali/webmonitor/storage"
-	"github.com/samirettali/webmonitor/utils"
 )
 
 type StorageHandler struct {
 	Storage storage.Storage
+	Monitor *monitor.Monitor
 	Logger  logger.Logger
+	// Events receives the mutations of checks, if set.
+	Events *events.Broker
+	// Origins are the origins besides the server's own that browsers may
+	// open WebSockets from.
+	Origins []string
+	// Version is the major version of the API the handler serves. Every
+	// version has its own handler so that the shape of the responses can
+	// change between them.
+	Version int
 }
 
-type Response struct {
-	Error string `json:"error"`
-}
+// V1Prefix is the path version 1 of the API is served under.
+const V1Prefix = apitypes.V1Prefix
 
 func (h *StorageHandler) GetCheck(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
 	params := mux.Vars(r)
 	id := params["id"]
-	check, err := h.Storage.GetCheck(r.Context(), id)
+	check, err := h.Storage.GetCheck(r.Context(), orgID, id)
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
 	if err != nil {
 		h.Logger.Errorf("get: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem(w, r, http.StatusInternalServerError, "")
 		return
 	}
 
@@ -41,98 +64,181 @@ func (h *StorageHandler) GetCheck(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(&check)
 }
 
+// GetChecks lists the checks of the organisation, optionally filtered and
+// paginated with the query parameters active, interval, q, changed_after,
+// changed_before, tag, group, sort, limit and cursor. Without a limit every matching
+// check is returned.
 func (h *StorageHandler) GetChecks(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	checks, err := h.Storage.GetChecks(r.Context())
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	filter, err := checkFilter(r)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.OrgID = orgID
+
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
+	}
+
+	checks, err := h.Storage.GetChecks(r.Context(), filter)
 	if err != nil {
 		h.Logger.Errorf("get: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem(w, r, http.StatusInternalServerError, "")
 		return
 	}
 
+	var next string
+	if limit > 0 && len(checks) > limit {
+		checks = checks[:limit]
+		last := &checks[limit-1]
+		next = encodeCursor(checkSortValue(last, filter.Sort), last.ID)
+	}
+
 	if len(checks) == 0 {
 		checks = make([]models.Check, 0)
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&checks)
+	h.writePage(w, &checks, next)
 }
 
 func (h *StorageHandler) CreateCheck(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	var check models.Check
-	dec := json.NewDecoder(r.Body)
-	err := dec.Decode(&check)
-	if err != nil {
-		h.Logger.Error("decode: ", err)
-		w.WriteHeader(http.StatusBadRequest)
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
 		return
 	}
 
-	v := validator.New()
-	err = v.Struct(check)
+	var check models.Check
+	if !h.decodeProblem(w, r, &check) {
+		return
+	}
 
+	rules, err := extract.Compile(&check)
 	if err != nil {
-		h.Logger.Error("validate: ", err)
-		w.WriteHeader(http.StatusBadRequest)
+		problem(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	body, err := utils.Request(check.URL)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		resp := Response{
-			Error: "The selected URL cannot be reached",
+	// The content of a page is saved as the first status, availability
+	// checks are created even if their page is down.
+	var prev previewResponse
+	if check.Kind != models.KindAvailability {
+		extendWriteDeadline(w, previewPolicy(&check).Budget())
+		prev, err = preview(r.Context(), &check, rules)
+		if err != nil {
+			problem(w, r, http.StatusBadRequest, "The selected URL cannot be reached",
+				FieldError{Field: "url", Rule: "reachable"})
+			return
 		}
-		json.NewEncoder(w).Encode(&resp)
-		return
 	}
 
 	check.ID = uuid.New().String()
-
-	status := models.Status{
-		ID:      uuid.New().String(),
-		Content: body,
-		CheckID: check.ID,
-		Date:    time.Now(),
+	check.OrgID = orgID
+	if check.Channels == nil {
+		check.Channels = make([]string, 0)
+	}
+	if check.Tags == nil {
+		check.Tags = make([]string, 0)
 	}
 
-	err = h.Storage.CreateCheck(r.Context(), &check)
-	if err != nil {
-		h.Logger.Errorf("save check: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	status := models.Status{
+		ID:       uuid.New().String(),
+		Content:  prev.Content,
+		CheckID:  check.ID,
+		Date:     time.Now(),
+		Response: prev.Response,
 	}
 
-	err = h.Storage.UpdateStatus(r.Context(), check.ID, &status)
-	if err != nil {
-		h.Logger.Errorf("add status: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	err = h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		err := h.Storage.CreateCheck(ctx, &check)
+		if err != nil {
+			return err
+		}
+		if check.Kind != models.KindAvailability {
+			err = h.Storage.UpdateStatus(ctx, check.ID, &status)
+			if err != nil {
+				return errors.Wrap(err, "can't add status")
+			}
+		}
+		return h.audit(ctx, r, orgID, "create", auditCheck, check.ID, nil, &check)
+	})
+	if !h.checkSaved(w, r, err) {
 		return
 	}
 
+	h.publishCheck(orgID, "create", check.ID, nil, &check)
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(&check)
 }
 
+// checkSaved writes the problem matching the error returned when saving a
+// check, if any, and returns whether it was saved.
+func (h *StorageHandler) checkSaved(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case sql.ErrNoRows:
+		problem(w, r, http.StatusNotFound, "The check does not exist")
+	case storage.ErrUnknownChannel:
+		problem(w, r, http.StatusBadRequest, err.Error(), FieldError{Field: "channels", Rule: "exists"})
+	case storage.ErrUnknownGroup:
+		problem(w, r, http.StatusBadRequest, err.Error(), FieldError{Field: "group_id", Rule: "exists"})
+	case storage.ErrDuplicate:
+		problem(w, r, http.StatusConflict, "The key is already used by another check", FieldError{Field: "key", Rule: "unique"})
+	default:
+		h.Logger.Errorf("save check: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+	}
+	return false
+}
+
 func (h *StorageHandler) DeleteCheck(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
 	params := mux.Vars(r)
 	id := params["id"]
-	err := h.Storage.DeleteCheck(r.Context(), id)
+	var before models.Check
+	err := h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		var err error
+		before, err = h.Storage.GetCheck(ctx, orgID, id)
+		if err != nil {
+			return err
+		}
+		err = h.Storage.DeleteCheck(ctx, orgID, id)
+		if err != nil {
+			return err
+		}
+		return h.audit(ctx, r, orgID, "delete", auditCheck, id, &before, nil)
+	})
 
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
 	if err != nil {
 		h.Logger.Errorf("delete: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		problem(w, r, http.StatusInternalServerError, "")
 		return
 	}
+
+	h.publishCheck(orgID, "delete", id, &before, nil)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -140,41 +246,212 @@ func (h *StorageHandler) UpdateCheck(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
 	var upd models.CheckUpdate
 	params := mux.Vars(r)
 	id := params["id"]
 
-	dec := json.NewDecoder(r.Body)
-	err := dec.Decode(&upd)
-	if err != nil && err != io.EOF {
-		w.WriteHeader(http.StatusBadRequest)
+	// An empty body changes nothing and returns the check as it is.
+	if !h.decodeOptional(w, r, &upd) {
 		return
 	}
 
-	check, err := h.Storage.UpdateCheck(r.Context(), id, &upd)
+	rules := models.Check{}
+	if upd.Extract != nil {
+		rules.Extract = *upd.Extract
+	}
+	if upd.Ignore != nil {
+		rules.Ignore = *upd.Ignore
+	}
+	_, err := extract.Compile(&rules)
 	if err != nil {
-		h.Logger.Error(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	var before, check models.Check
+	err = h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		var err error
+		before, err = h.Storage.GetCheck(ctx, orgID, id)
+		if err != nil {
+			return err
+		}
+		check, err = h.Storage.UpdateCheck(ctx, orgID, id, &upd)
+		if err != nil {
+			return err
+		}
+		return h.audit(ctx, r, orgID, checkUpdateAction(&before, &check), auditCheck, id, &before, &check)
+	})
+	if !h.checkSaved(w, r, err) {
+		return
+	}
+
+	h.publishCheck(orgID, checkUpdateAction(&before, &check), id, &before, &check)
 	json.NewEncoder(w).Encode(check)
 }
 
+// historyFields are the fields of a status that can be requested with the
+// fields query parameter of GetHistory.
+var historyFields = map[string]func(*models.Status) interface{}{
+	"id":       func(s *models.Status) interface{} { return s.ID },
+	"date":     func(s *models.Status) interface{} { return s.Date },
+	"size":     func(s *models.Status) interface{} { return s.Size },
+	"hash":     func(s *models.Status) interface{} { return s.Hash },
+	"content":  func(s *models.Status) interface{} { return s.Content },
+	"response": func(s *models.Status) interface{} { return s.Response },
+}
+
+// GetHistory lists the statuses of a check, newest first unless order=asc.
+// The listing can be restricted to [from, to) and to a subset of the
+// fields, leaving the content out to only transfer metadata. It is only
+// paginated when a limit or a cursor is given, like GetChecks.
 func (h *StorageHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
 	params := mux.Vars(r)
 	id := params["id"]
+	query := r.URL.Query()
+
+	filter := models.HistoryFilter{WithContent: true}
+	var err error
+	var fields []string
+
+	badRequest := func(message string) {
+		problem(w, r, http.StatusBadRequest, message)
+	}
+
+	if query.Get("limit") != "" || query.Get("cursor") != "" {
+		if filter.Limit, err = pageSize(r); err != nil {
+			badRequest("Invalid limit")
+			return
+		}
+	}
+
+	if filter.From, err = timeParam(r, "from"); err != nil {
+		badRequest("Invalid from")
+		return
+	}
+
+	if filter.To, err = timeParam(r, "to"); err != nil {
+		badRequest("Invalid to")
+		return
+	}
+
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		badRequest("Invalid order")
+		return
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		var date string
+		date, filter.AfterID, err = decodeCursor(cursor)
+		if err == nil {
+			filter.AfterDate, err = time.Parse(time.RFC3339Nano, date)
+		}
+		if err != nil {
+			badRequest("Invalid cursor")
+			return
+		}
+	}
+
+	if raw := query.Get("fields"); raw != "" {
+		fields = strings.Split(raw, ",")
+		filter.WithContent = false
+		for _, field := range fields {
+			if _, ok := historyFields[field]; !ok {
+				badRequest("Invalid field " + field)
+				return
+			}
+			if field == "content" {
+				filter.WithContent = true
+			}
+		}
+	}
+
+	_, err = h.Storage.GetCheck(r.Context(), orgID, id)
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("get check: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
 
-	statuses, err := h.Storage.GetHistory(r.Context(), id)
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
+	}
+	statuses, err := h.Storage.GetHistory(r.Context(), orgID, id, filter)
 	if err != nil {
 		h.Logger.Errorf("get history: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	var next string
+	if limit > 0 && len(statuses) > limit {
+		statuses = statuses[:limit]
+		last := statuses[limit-1]
+		next = encodeCursor(last.Date.Format(time.RFC3339Nano), last.ID)
+	}
+
+	if len(statuses) == 0 {
+		statuses = make([]models.Status, 0)
+	}
+
+	if fields == nil {
+		h.writePage(w, &statuses, next)
+		return
+	}
+
+	projected := make([]map[string]interface{}, len(statuses))
+	for i := range statuses {
+		projected[i] = make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			projected[i][field] = historyFields[field](&statuses[i])
+		}
+	}
+	h.writePage(w, &projected, next)
+}
+
+// GetHistoryStatus returns a single status of a check with its content.
+func (h *StorageHandler) GetHistoryStatus(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	params := mux.Vars(r)
+	status, err := h.Storage.GetHistoryStatus(r.Context(), orgID, params["id"], params["status"])
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The status does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("get status: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&statuses)
-}
\ No newline at end of file
+	json.NewEncoder(w).Encode(&status)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// healthTimeout bounds the time taken by the readiness checks, which must
+// answer before the orchestrator gives up on the probe.
+const healthTimeout = 5 * time.Second
+
+// healthReport is the body of the health endpoints. Checks holds the
+// result of every readiness check by name.
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Healthz tells that the process is alive and able to serve requests.
+func (h *StorageHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	writeHealth(w, healthReport{Status: "ok"})
+}
+
+// Readyz tells whether the server can do its job: the database can be
+// reached, the scheduler is running the checks on time and the notifier is
+// configured. It answers 503 with the failed checks otherwise.
+func (h *StorageHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	report := healthReport{Status: "ok", Checks: make(map[string]healthCheck)}
+	for name, err := range map[string]error{
+		"database":  h.Storage.Ping(ctx),
+		"scheduler": h.Monitor.CheckScheduler(time.Now()),
+		"notifier":  h.Monitor.CheckNotifier(),
+	} {
+		check := healthCheck{Status: "ok"}
+		if err != nil {
+			check = healthCheck{Status: "failed", Error: err.Error()}
+			report.Status = "unavailable"
+		}
+		report.Checks[name] = check
+	}
+
+	writeHealth(w, report)
+}
+
+func writeHealth(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed openapi.yaml
+var openAPISource []byte
+
+//go:embed swagger.html
+var swaggerPage []byte
+
+// openAPIDocument is the OpenAPI specification of the API, converted to
+// JSON once.
+var openAPIDocument = mustOpenAPIDocument()
+
+func mustOpenAPIDocument() []byte {
+	var doc interface{}
+	err := yaml.Unmarshal(openAPISource, &doc)
+	if err != nil {
+		panic(fmt.Sprintf("openapi.yaml: %v", err))
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		panic(fmt.Sprintf("openapi.yaml: %v", err))
+	}
+	return buf
+}
+
+// OpenAPI serves the OpenAPI specification of the API.
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIDocument)
+}
+
+// SwaggerUI serves a page rendering the OpenAPI specification with Swagger
+// UI.
+func SwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(swaggerPage)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/auth"
+)
+
+// TestOpenAPIRoutes checks that the specification describes every route,
+// since clients are generated from it. OPTIONS is left out because it is
+// only used by CORS preflight requests.
+func TestOpenAPIRoutes(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err := json.Unmarshal(openAPIDocument, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	v1 := router.PathPrefix(V1Prefix).Subrouter()
+	Routes(v1, &StorageHandler{}, &auth.Authenticator{}, &auth.OIDC{})
+
+	count := 0
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		// Subrouters have neither a path nor methods of their own.
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path = strings.TrimPrefix(path, V1Prefix)
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			count++
+			if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is missing from openapi.yaml", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("no route was registered")
+	}
+}
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/apitypes"
+	"github.com/samirettali/webmonitor/auth"
+	"github.com/samirettali/webmonitor/models"
+)
+
+// OrgHeader selects the organisation a request operates on, see
+// apitypes.OrgHeader.
+const OrgHeader = apitypes.OrgHeader
+
+// authorize makes sure the authenticated user holds at least role in the
+// organisation targeted by the request and returns that organisation's ID.
+// When it returns false a response has already been written.
+func (h *StorageHandler) authorize(w http.ResponseWriter, r *http.Request, role models.Role) (string, bool) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusUnauthorized, "The request is not authenticated")
+		return "", false
+	}
+
+	orgID := mux.Vars(r)["org"]
+	if orgID == "" {
+		orgID = r.Header.Get(OrgHeader)
+	}
+
+	var membership models.Membership
+	if orgID == "" {
+		memberships, err := h.Storage.GetMemberships(r.Context(), user.ID)
+		if err != nil {
+			h.Logger.Errorf("get memberships: %v", err)
+			problem(w, r, http.StatusInternalServerError, "")
+			return "", false
+		}
+		if len(memberships) == 0 {
+			problem(w, r, http.StatusForbidden, "The user is not a member of any organisation")
+			return "", false
+		}
+		membership = memberships[0]
+	} else {
+		var err error
+		membership, err = h.Storage.GetMembership(r.Context(), orgID, user.ID)
+		if err == sql.ErrNoRows {
+			problem(w, r, http.StatusForbidden, "The user is not a member of the organisation")
+			return "", false
+		}
+		if err != nil {
+			h.Logger.Errorf("get membership: %v", err)
+			problem(w, r, http.StatusInternalServerError, "")
+			return "", false
+		}
+	}
+
+	if !membership.Role.Allows(role) {
+		problem(w, r, http.StatusForbidden, "The role of the user doesn't allow the request")
+		return "", false
+	}
+
+	return membership.OrgID, true
+}
+
+func (h *StorageHandler) GetOrganisations(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusUnauthorized, "The request is not authenticated")
+		return
+	}
+
+	orgs, err := h.Storage.GetOrganisations(r.Context(), user.ID)
+	if err != nil {
+		h.Logger.Errorf("get organisations: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	if len(orgs) == 0 {
+		orgs = make([]models.Organisation, 0)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&orgs)
+}
+
+func (h *StorageHandler) CreateOrganisation(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusUnauthorized, "The request is not authenticated")
+		return
+	}
+
+	var org models.Organisation
+	if !h.decodeProblem(w, r, &org) {
+		return
+	}
+
+	org.ID = uuid.NewString()
+	org.Created = time.Now()
+
+	err := h.Storage.CreateOrganisation(r.Context(), &org, user.ID)
+	if err != nil {
+		h.Logger.Errorf("create organisation: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&org)
+}
+
+func (h *StorageHandler) GetMembers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := h.Storage.GetMembers(r.Context(), orgID)
+	if err != nil {
+		h.Logger.Errorf("get members: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&members)
+}
+
+type memberRequest struct {
+	Email string      `json:"email" validate:"required,email"`
+	Role  models.Role `json:"role" validate:"required,oneof=viewer editor admin"`
+}
+
+type memberResponse struct {
+	models.Membership
+	// APIKey is only returned when the member did not have an account yet.
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// AddMember adds a user to the organisation, creating an account for them
+// if the email is unknown. Existing members are changed with UpdateMember,
+// which makes sure the organisation keeps an admin.
+func (h *StorageHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	var req memberRequest
+	if !h.decodeProblem(w, r, &req) {
+		return
+	}
+
+	var resp memberResponse
+	user, err := h.Storage.GetUserByEmail(r.Context(), req.Email)
+	if err == sql.ErrNoRows {
+		key, hash, kerr := auth.NewAPIKey()
+		if kerr != nil {
+			h.Logger.Errorf("generate api key: %v", kerr)
+			problem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		user = models.User{
+			ID:         uuid.NewString(),
+			Email:      req.Email,
+			APIKeyHash: hash,
+			Created:    time.Now(),
+		}
+		err = h.Storage.CreateUser(r.Context(), &user)
+		resp.APIKey = key
+	}
+	if err != nil {
+		h.Logger.Errorf("get user: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	// A new account can't be a member yet.
+	if resp.APIKey == "" {
+		_, err = h.Storage.GetMembership(r.Context(), orgID, user.ID)
+		if err == nil {
+			problem(w, r, http.StatusConflict, "The user is already a member of the organisation", FieldError{Field: "email", Rule: "unique"})
+			return
+		}
+		if err != sql.ErrNoRows {
+			h.Logger.Errorf("get member: %v", err)
+			problem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	resp.Membership = models.Membership{
+		OrgID:   orgID,
+		UserID:  user.ID,
+		Email:   user.Email,
+		Role:    req.Role,
+		Created: time.Now(),
+	}
+
+	err = h.Storage.SetMembership(r.Context(), &resp.Membership)
+	if err != nil {
+		h.Logger.Errorf("add member: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&resp)
+}
+
+func (h *StorageHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	var upd struct {
+		Role models.Role `json:"role" validate:"required,oneof=viewer editor admin"`
+	}
+	if !h.decodeProblem(w, r, &upd) {
+		return
+	}
+
+	membership, err := h.Storage.GetMembership(r.Context(), orgID, mux.Vars(r)["user"])
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The member does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("get member: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	if upd.Role != models.RoleAdmin && !h.keepsAdmin(w, r, membership) {
+		return
+	}
+
+	membership.Role = upd.Role
+	err = h.Storage.SetMembership(r.Context(), &membership)
+	if err != nil {
+		h.Logger.Errorf("update member: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&membership)
+}
+
+func (h *StorageHandler) DeleteMember(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	membership, err := h.Storage.GetMembership(r.Context(), orgID, mux.Vars(r)["user"])
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The member does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("get member: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	if !h.keepsAdmin(w, r, membership) {
+		return
+	}
+
+	err = h.Storage.DeleteMembership(r.Context(), orgID, membership.UserID)
+	if err != nil {
+		h.Logger.Errorf("delete member: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keepsAdmin reports whether the organisation would still have an
+// administrator if membership lost its admin role.
+func (h *StorageHandler) keepsAdmin(w http.ResponseWriter, r *http.Request, membership models.Membership) bool {
+	if membership.Role != models.RoleAdmin {
+		return true
+	}
+
+	members, err := h.Storage.GetMembers(r.Context(), membership.OrgID)
+	if err != nil {
+		h.Logger.Errorf("get members: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return false
+	}
+
+	for _, m := range members {
+		if m.UserID != membership.UserID && m.Role == models.RoleAdmin {
+			return true
+		}
+	}
+
+	problem(w, r, http.StatusConflict, "An organisation needs at least one admin")
+	return false
+}
+
+// RotateAPIKey replaces the API key of the authenticated user and returns
+// the new one.
+func (h *StorageHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusUnauthorized, "The request is not authenticated")
+		return
+	}
+
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		h.Logger.Errorf("generate api key: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = h.Storage.SetUserAPIKey(r.Context(), user.ID, hash)
+	if err != nil {
+		h.Logger.Errorf("set api key: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"api_key": key})
+}
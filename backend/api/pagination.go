@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samirettali/webmonitor/apitypes"
+)
+
+// NextCursorHeader carries the cursor of the next page of a paginated
+// listing, see apitypes.NextCursorHeader.
+const NextCursorHeader = apitypes.NextCursorHeader
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// pageSize reads the limit query parameter, falling back to the default
+// page size when it is missing and clamping it to the maximum one.
+func pageSize(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 {
+		return 0, strconv.ErrSyntax
+	}
+
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
+
+// encodeCursor builds an opaque cursor from the position of the last item
+// of a page, made of the value it is sorted by and its ID.
+func encodeCursor(value string, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(value + "\x00" + id))
+}
+
+func decodeCursor(cursor string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", err
+	}
+
+	value, id, ok := strings.Cut(string(raw), "\x00")
+	if !ok || id == "" {
+		return "", "", strconv.ErrSyntax
+	}
+	return value, id, nil
+}
+
+// timeParam reads an optional RFC 3339 timestamp from the query string.
+func timeParam(r *http.Request, name string) (time.Time, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// writePage writes a page of a paginated listing. next is the cursor of the
+// next page, empty on the last one. Version 1 of the API returns the items
+// as a bare array and the cursor in NextCursorHeader, a later version can
+// wrap them in an envelope instead.
+func (h *StorageHandler) writePage(w http.ResponseWriter, items interface{}, next string) {
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(items)
+}
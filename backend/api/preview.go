@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samirettali/webmonitor/extract"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/monitor"
+	"github.com/samirettali/webmonitor/utils"
+)
+
+// largePage is the size above which previews warn that a page is large.
+const largePage = 1 << 20
+
+type previewResponse struct {
+	StatusCode      int            `json:"status_code"`
+	ContentType     string         `json:"content_type"`
+	DurationMS      int64          `json:"duration_ms"`
+	RawSize         int            `json:"raw_size"`
+	Size            int            `json:"size"`
+	Content         string         `json:"content"`
+	Transformations []extract.Step `json:"transformations"`
+	Warnings        []string       `json:"warnings"`
+	// Response describes the response, including the timing of the
+	// request.
+	Response *models.Response `json:"response"`
+}
+
+// previewPolicy fetches the page of a check once, with its timeout.
+func previewPolicy(check *models.Check) utils.Policy {
+	policy := monitor.FetchPolicy(check)
+	policy.Retries = 0
+	if max := models.MaxTimeout * time.Millisecond; policy.Timeout > max {
+		policy.Timeout = max
+	}
+	return policy
+}
+
+// preview fetches the page of a check once and applies its rules.
+func preview(ctx context.Context, check *models.Check, rules *extract.Rules) (previewResponse, error) {
+	resp, err := utils.Fetch(ctx, check.URL, check.Headers, previewPolicy(check))
+	if err != nil {
+		return previewResponse{}, err
+	}
+
+	result := rules.Apply(resp.Body)
+	prev := previewResponse{
+		StatusCode:      resp.StatusCode,
+		ContentType:     resp.ContentType,
+		DurationMS:      resp.Duration.Milliseconds(),
+		RawSize:         len(resp.Body),
+		Size:            len(result.Content),
+		Content:         result.Content,
+		Transformations: result.Steps,
+		Response:        resp.Info,
+	}
+
+	var warnings []string
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		warnings = append(warnings, fmt.Sprintf("The server answered with status %d", resp.StatusCode))
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.ContentType); err == nil && !textual(mediaType) {
+		warnings = append(warnings, fmt.Sprintf("The page is not text but %s", mediaType))
+	}
+	if len(resp.Body) > largePage {
+		warnings = append(warnings, "The page is larger than 1 MiB, consider extracting the relevant part")
+	}
+	prev.Warnings = append(warnings, result.Warnings...)
+
+	return prev, nil
+}
+
+func textual(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml")
+}
+
+// PreviewCheck fetches the page of a draft check and shows the content it
+// would monitor, without saving anything. Only the URL, the headers and the
+// rules of the draft are used.
+func (h *StorageHandler) PreviewCheck(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	_, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var check models.Check
+	errs, err := decodeJSON(r, &check)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, decodeDetail(err))
+		return
+	}
+	if len(errs) > 0 {
+		problem(w, r, http.StatusBadRequest, "The request contains invalid fields", errs...)
+		return
+	}
+
+	err = newValidator().Var(check.URL, "required,url")
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, "A valid URL is required", FieldError{Field: "url", Rule: "url"})
+		return
+	}
+
+	rules, err := extract.Compile(&check)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	extendWriteDeadline(w, previewPolicy(&check).Budget())
+	prev, err := preview(r.Context(), &check, rules)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, "The selected URL cannot be reached", FieldError{Field: "url", Rule: "reachable"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&prev)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/samirettali/webmonitor/apitypes"
+)
+
+// ProblemContentType is the media type of the error bodies.
+const ProblemContentType = apitypes.ProblemContentType
+
+// Problem is the error body of the responses, see apitypes.Problem.
+type Problem = apitypes.Problem
+
+// problem writes an error response with a problem body.
+func problem(w http.ResponseWriter, r *http.Request, status int, detail string, errs ...FieldError) {
+	apitypes.WriteProblem(w, r, status, detail, errs...)
+}
+
+// errEmptyBody is returned by decodeJSON when the request has no body.
+var errEmptyBody = errors.New("the request body is empty")
+
+// decodeJSON decodes the body of a request into v, rejecting unknown fields.
+// Unknown fields and values of the wrong type are returned as field errors.
+func decodeJSON(r *http.Request, v interface{}) ([]FieldError, error) {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == io.EOF {
+		return nil, errEmptyBody
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []FieldError{{Field: typeErr.Field, Rule: "type", Param: typeErr.Type.String()}}, nil
+	}
+
+	// The json package has no error type for unknown fields.
+	const unknownPrefix = "json: unknown field "
+	if err != nil && strings.HasPrefix(err.Error(), unknownPrefix) {
+		field := strings.Trim(strings.TrimPrefix(err.Error(), unknownPrefix), `"`)
+		return []FieldError{{Field: field, Rule: "unknown"}}, nil
+	}
+
+	return nil, err
+}
+
+// decodeDetail describes an error returned by decodeJSON.
+func decodeDetail(err error) string {
+	if err == errEmptyBody {
+		return "The request body is empty"
+	}
+	return fmt.Sprintf("The request body is not valid JSON: %v", err)
+}
+
+// decodeProblem decodes the body of a request into v and validates it,
+// writing a problem response and returning false if it fails.
+func (h *StorageHandler) decodeProblem(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return h.decodeBody(w, r, v, false)
+}
+
+// decodeOptional is like decodeProblem, but a request without a body leaves
+// v untouched instead of failing. It serves updates, which may change
+// nothing.
+func (h *StorageHandler) decodeOptional(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return h.decodeBody(w, r, v, true)
+}
+
+func (h *StorageHandler) decodeBody(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	errs, err := decodeJSON(r, v)
+	if err == errEmptyBody && optional {
+		err = nil
+	}
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, decodeDetail(err))
+		return false
+	}
+	if len(errs) > 0 {
+		problem(w, r, http.StatusBadRequest, "The request contains invalid fields", errs...)
+		return false
+	}
+
+	err = newValidator().Struct(v)
+	if err != nil {
+		problem(w, r, http.StatusBadRequest, "The request contains invalid fields", fieldErrors(err)...)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/auth"
+)
+
+// Routes registers the handlers of the API on router. sso is nil when OpenID
+// Connect is not configured.
+func Routes(router *mux.Router, handler *StorageHandler, authenticator *auth.Authenticator, sso *auth.OIDC) {
+	router.HandleFunc("/openapi.json", OpenAPI).Methods(http.MethodGet)
+	router.HandleFunc("/docs", SwaggerUI).Methods(http.MethodGet)
+
+	if sso != nil {
+		router.HandleFunc("/auth/login", sso.Login).Methods(http.MethodGet)
+		router.HandleFunc("/auth/callback", sso.Callback).Methods(http.MethodGet)
+		router.HandleFunc("/auth/logout", sso.Logout).Methods(http.MethodPost, http.MethodOptions)
+	}
+
+	protected := router.NewRoute().Subrouter()
+	protected.Use(authenticator.Middleware)
+	protected.HandleFunc("/checks", handler.GetChecks).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/checks", handler.CreateCheck).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/checks/preview", handler.PreviewCheck).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/checks/bulk", handler.BulkUpdateChecks).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/checks/export", handler.ExportChecks).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/checks/import", handler.ImportChecks).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/checks/import/bookmarks", handler.ImportBookmarks).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}", handler.GetCheck).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}", handler.DeleteCheck).Methods(http.MethodDelete, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}", handler.UpdateCheck).Methods(http.MethodPatch, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}/run", handler.RunCheck).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}/runs", handler.GetRuns).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}/history", handler.GetHistory).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}/history/{status}", handler.GetHistoryStatus).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}/uptime", handler.GetUptime).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/checks/{id}/probes", handler.GetProbes).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/channels", handler.GetChannels).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/channels", handler.CreateChannel).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/channels/{id}", handler.GetChannel).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/channels/{id}", handler.DeleteChannel).Methods(http.MethodDelete, http.MethodOptions)
+	protected.HandleFunc("/channels/{id}", handler.UpdateChannel).Methods(http.MethodPatch, http.MethodOptions)
+	protected.HandleFunc("/tags", handler.GetTags).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/tags", handler.CreateTag).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/tags/{id}", handler.DeleteTag).Methods(http.MethodDelete, http.MethodOptions)
+	protected.HandleFunc("/tags/{id}", handler.UpdateTag).Methods(http.MethodPatch, http.MethodOptions)
+	protected.HandleFunc("/groups", handler.GetGroups).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/groups", handler.CreateGroup).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/groups/{id}", handler.DeleteGroup).Methods(http.MethodDelete, http.MethodOptions)
+	protected.HandleFunc("/groups/{id}", handler.UpdateGroup).Methods(http.MethodPatch, http.MethodOptions)
+	protected.HandleFunc("/orgs", handler.GetOrganisations).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/orgs", handler.CreateOrganisation).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/orgs/{org}/members", handler.GetMembers).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/orgs/{org}/members", handler.AddMember).Methods(http.MethodPost, http.MethodOptions)
+	protected.HandleFunc("/orgs/{org}/members/{user}", handler.UpdateMember).Methods(http.MethodPatch, http.MethodOptions)
+	protected.HandleFunc("/orgs/{org}/members/{user}", handler.DeleteMember).Methods(http.MethodDelete, http.MethodOptions)
+	protected.HandleFunc("/events", handler.StreamEvents).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/ws", handler.Subscribe).Methods(http.MethodGet)
+	protected.HandleFunc("/audit", handler.GetAuditLog).Methods(http.MethodGet, http.MethodOptions)
+	protected.HandleFunc("/users/me/key", handler.RotateAPIKey).Methods(http.MethodPost, http.MethodOptions)
+}
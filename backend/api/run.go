@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/monitor"
+)
+
+// writeMargin is the time left to write a response after an operation
+// whose handler extends its write deadline.
+const writeMargin = 3 * time.Second
+
+// extendWriteDeadline lets a handler spend d on an operation, which may take
+// longer than the server allows by default, before writing its response.
+func extendWriteDeadline(w http.ResponseWriter, d time.Duration) {
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + writeMargin))
+}
+
+type runResponse struct {
+	Changed    bool   `json:"changed"`
+	Baseline   bool   `json:"baseline"`
+	Notified   bool   `json:"notified"`
+	StatusCode int    `json:"status_code"`
+	DurationMS int64  `json:"duration_ms"`
+	StatusID   string `json:"status_id,omitempty"`
+	Diff       string `json:"diff,omitempty"`
+	RunID      string `json:"run_id,omitempty"`
+	Attempts   int    `json:"attempts"`
+	// Up, ProbeID, Reason and Transition are set by availability checks.
+	Up         *bool  `json:"up,omitempty"`
+	ProbeID    string `json:"probe_id,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Transition string `json:"transition,omitempty"`
+	// Response describes the response of the page, if it was fetched.
+	Response *models.Response `json:"response,omitempty"`
+}
+
+// RunCheck runs a check immediately, whether it is active or not, as the
+// monitor would on schedule. Notifications are sent unless notify=false.
+func (h *StorageHandler) RunCheck(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	notify := true
+	if raw := r.URL.Query().Get("notify"); raw != "" {
+		var err error
+		notify, err = strconv.ParseBool(raw)
+		if err != nil {
+			problem(w, r, http.StatusBadRequest, "Invalid notify")
+			return
+		}
+	}
+
+	check, err := h.Storage.GetCheck(r.Context(), orgID, mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("get check: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	// The run takes as long as the timeout and the retries of the check
+	// allow.
+	timeout := monitor.RunTimeout(&check)
+	extendWriteDeadline(w, timeout)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	result, err := h.Monitor.Run(ctx, &check, notify)
+	if _, ok := err.(*monitor.FetchError); ok {
+		problem(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("run check %s: %v", check.ID, err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	resp := runResponse{
+		Changed:    result.Changed,
+		Baseline:   result.Baseline,
+		Notified:   result.Notified,
+		StatusCode: result.Code,
+		DurationMS: result.Duration.Milliseconds(),
+		Attempts:   result.Attempts,
+		Diff:       result.Diff,
+		Response:   result.Response,
+	}
+	if result.Status != nil {
+		resp.StatusID = result.Status.ID
+	}
+	if result.Run != nil {
+		resp.RunID = result.Run.ID
+	}
+	if result.Probe != nil {
+		resp.Up = &result.Probe.Up
+		resp.ProbeID = result.Probe.ID
+		resp.Reason = result.Probe.Reason
+		resp.Transition = result.Transition
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&resp)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/models"
+)
+
+// GetRuns lists the runs of a check, newest first, optionally restricted
+// to an outcome and to [from, to).
+func (h *StorageHandler) GetRuns(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.RunFilter{Outcome: query.Get("outcome")}
+	var err error
+
+	if filter.Outcome != "" && !contains(models.RunOutcomes, filter.Outcome) {
+		problem(w, r, http.StatusBadRequest, "Invalid outcome")
+		return
+	}
+
+	if filter.Limit, err = pageSize(r); err != nil {
+		problem(w, r, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	if filter.From, err = timeParam(r, "from"); err != nil {
+		problem(w, r, http.StatusBadRequest, "Invalid from")
+		return
+	}
+
+	if filter.To, err = timeParam(r, "to"); err != nil {
+		problem(w, r, http.StatusBadRequest, "Invalid to")
+		return
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		var date string
+		date, filter.AfterID, err = decodeCursor(cursor)
+		if err == nil {
+			filter.AfterDate, err = time.Parse(time.RFC3339Nano, date)
+		}
+		if err != nil {
+			problem(w, r, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	runs, err := h.Storage.GetRuns(r.Context(), orgID, mux.Vars(r)["id"], filter)
+	if err != nil {
+		h.Logger.Errorf("get runs: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	var next string
+	if len(runs) > limit {
+		runs = runs[:limit]
+		last := runs[limit-1]
+		next = encodeCursor(last.Date.Format(time.RFC3339Nano), last.ID)
+	}
+
+	if len(runs) == 0 {
+		runs = make([]models.Run, 0)
+	}
+
+	h.writePage(w, &runs, next)
+}
@@ -0,0 +1,350 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/storage"
+)
+
+type renameRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=50"`
+}
+
+func (h *StorageHandler) GetTags(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	tags, err := h.Storage.GetTags(r.Context(), orgID)
+	if err != nil {
+		h.Logger.Errorf("get tags: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	if len(tags) == 0 {
+		tags = make([]models.Tag, 0)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&tags)
+}
+
+func (h *StorageHandler) CreateTag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var tag models.Tag
+	if !h.decodeProblem(w, r, &tag) {
+		return
+	}
+
+	tag.ID = uuid.NewString()
+	tag.OrgID = orgID
+	tag.Checks = 0
+
+	err := h.Storage.CreateTag(r.Context(), &tag)
+	if err == storage.ErrDuplicate {
+		problem(w, r, http.StatusConflict, "The name is already used by another tag", FieldError{Field: "name", Rule: "unique"})
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("create tag: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&tag)
+}
+
+func (h *StorageHandler) UpdateTag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var req renameRequest
+	if !h.decodeProblem(w, r, &req) {
+		return
+	}
+
+	tag, err := h.Storage.RenameTag(r.Context(), orgID, mux.Vars(r)["id"], req.Name)
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The tag does not exist")
+		return
+	}
+	if err == storage.ErrDuplicate {
+		problem(w, r, http.StatusConflict, "The name is already used by another tag", FieldError{Field: "name", Rule: "unique"})
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("update tag: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&tag)
+}
+
+func (h *StorageHandler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	err := h.Storage.DeleteTag(r.Context(), orgID, mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The tag does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("delete tag: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *StorageHandler) GetGroups(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	groups, err := h.Storage.GetGroups(r.Context(), orgID)
+	if err != nil {
+		h.Logger.Errorf("get groups: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	if len(groups) == 0 {
+		groups = make([]models.Group, 0)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&groups)
+}
+
+func (h *StorageHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var group models.Group
+	if !h.decodeProblem(w, r, &group) {
+		return
+	}
+
+	group.ID = uuid.NewString()
+	group.OrgID = orgID
+	group.Checks = 0
+
+	err := h.Storage.CreateGroup(r.Context(), &group)
+	if err != nil {
+		h.Logger.Errorf("create group: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&group)
+}
+
+func (h *StorageHandler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var req renameRequest
+	if !h.decodeProblem(w, r, &req) {
+		return
+	}
+
+	group, err := h.Storage.RenameGroup(r.Context(), orgID, mux.Vars(r)["id"], req.Name)
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The group does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("update group: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&group)
+}
+
+func (h *StorageHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	err := h.Storage.DeleteGroup(r.Context(), orgID, mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		problem(w, r, http.StatusNotFound, "The group does not exist")
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("delete group: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type bulkRequest struct {
+	// Tag or GroupID select the checks the action is applied to.
+	Tag      string `json:"tag"`
+	GroupID  string `json:"group_id"`
+	Action   string `json:"action" validate:"required,oneof=pause resume delete set_interval"`
+	Interval uint64 `json:"interval"`
+}
+
+type bulkResponse struct {
+	Checks []string `json:"checks"`
+}
+
+// BulkUpdateChecks pauses, resumes, deletes or changes the interval of
+// every check with a tag or in a group, all of them or none, and returns
+// the IDs of the checks it changed.
+func (h *StorageHandler) BulkUpdateChecks(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var req bulkRequest
+	if !h.decodeProblem(w, r, &req) {
+		return
+	}
+
+	if (req.Tag == "") == (req.GroupID == "") {
+		problem(w, r, http.StatusBadRequest, "Exactly one of tag and group_id is required")
+		return
+	}
+
+	var upd models.CheckUpdate
+	switch req.Action {
+	case "pause", "resume":
+		active := req.Action == "resume"
+		upd.Active = &active
+	case "set_interval":
+		if req.Interval == 0 {
+			problem(w, r, http.StatusBadRequest, "The interval is required")
+			return
+		}
+		upd.Interval = &req.Interval
+	}
+
+	checks, err := h.Storage.GetChecks(r.Context(), models.CheckFilter{
+		OrgID:   orgID,
+		Tag:     req.Tag,
+		GroupID: req.GroupID,
+	})
+	if err != nil {
+		h.Logger.Errorf("get checks: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Every check is changed in one transaction, and the events are only
+	// published once it is committed.
+	type published struct {
+		action string
+		before *models.Check
+		// after stays nil when the check is deleted.
+		after interface{}
+	}
+	var events []published
+	var current string
+	err = h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		events = events[:0]
+		for i := range checks {
+			before := &checks[i]
+			current = before.ID
+			if req.Action == "delete" {
+				err := h.Storage.DeleteCheck(ctx, orgID, before.ID)
+				if err != nil {
+					return err
+				}
+				err = h.audit(ctx, r, orgID, req.Action, auditCheck, before.ID, before, nil)
+				if err != nil {
+					return err
+				}
+				events = append(events, published{req.Action, before, nil})
+				continue
+			}
+
+			updated, err := h.Storage.UpdateCheck(ctx, orgID, before.ID, &upd)
+			if err != nil {
+				return err
+			}
+			action := checkUpdateAction(before, &updated)
+			err = h.audit(ctx, r, orgID, action, auditCheck, before.ID, before, &updated)
+			if err != nil {
+				return err
+			}
+			events = append(events, published{action, before, &updated})
+		}
+		return nil
+	})
+	if err != nil {
+		h.Logger.Errorf("bulk %s %s: %v", req.Action, current, err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	resp := bulkResponse{Checks: make([]string, 0, len(events))}
+	for _, event := range events {
+		h.publishCheck(orgID, event.action, event.before.ID, event.before, event.after)
+		resp.Checks = append(resp.Checks, event.before.ID)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&resp)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/auth"
+	"github.com/samirettali/webmonitor/middlewares"
+	"github.com/samirettali/webmonitor/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestServerSpans sends a request to every route and checks that it is
+// traced by a single server span named after the route template. The
+// requests are unauthenticated so that they don't reach the storage.
+func TestServerSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), 1)
+	previous := otel.GetTracerProvider()
+	tracing.Install(provider)
+	defer tracing.Install(previous)
+
+	router := mux.NewRouter()
+	router.Use(middlewares.Tracing)
+	v1 := router.PathPrefix(V1Prefix).Subrouter()
+	Routes(v1, &StorageHandler{}, &auth.Authenticator{}, nil)
+
+	variable := regexp.MustCompile(`\{[^}]+\}`)
+	count := 0
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			count++
+			exporter.Reset()
+			req := httptest.NewRequest(method, variable.ReplaceAllString(template, "x"), nil)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Errorf("%s %s: %d spans, want 1", method, template, len(spans))
+				continue
+			}
+			span := spans[0]
+			if span.Name != method+" "+template {
+				t.Errorf("%s %s: span named %s", method, template, span.Name)
+			}
+			if span.SpanKind != trace.SpanKindServer {
+				t.Errorf("%s %s: span of kind %s", method, template, span.SpanKind)
+			}
+			route := ""
+			for _, attr := range span.Attributes {
+				if attr.Key == semconv.HTTPRouteKey {
+					route = attr.Value.AsString()
+				}
+			}
+			if route != template {
+				t.Errorf("%s %s: %s is %q", method, template, semconv.HTTPRouteKey, route)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("no route was registered")
+	}
+}
+
+// TestServerSpanParent checks that the span of a request continues the trace
+// of the caller.
+func TestServerSpanParent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), 1)
+	previous := otel.GetTracerProvider()
+	tracing.Install(provider)
+	defer tracing.Install(previous)
+
+	router := mux.NewRouter()
+	router.Use(middlewares.Tracing)
+	Routes(router.PathPrefix(V1Prefix).Subrouter(), &StorageHandler{}, &auth.Authenticator{}, nil)
+
+	ctx, caller := tracing.Tracer().Start(context.Background(), "client")
+	req := httptest.NewRequest(http.MethodGet, V1Prefix+"/checks", nil)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	caller.End()
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var server *tracetest.SpanStub
+	spans := exporter.GetSpans()
+	for i := range spans {
+		if spans[i].SpanKind == trace.SpanKindServer {
+			server = &spans[i]
+		}
+	}
+	if server == nil {
+		t.Fatal("no server span")
+	}
+	if server.Parent.SpanID() != caller.SpanContext().SpanID() || server.SpanContext.TraceID() != caller.SpanContext().TraceID() {
+		t.Errorf("the server span doesn't continue the trace of the caller")
+	}
+}
@@ -0,0 +1,678 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/samirettali/webmonitor/extract"
+	"github.com/samirettali/webmonitor/models"
+	"gopkg.in/yaml.v3"
+)
+
+// maxImportSize is the largest file accepted by ImportChecks.
+const maxImportSize = 10 << 20
+
+// checkRecord is the representation of a check in exported and imported
+// files. Channels are referred to by ID and tags by name.
+type checkRecord struct {
+	ID               string            `json:"id" yaml:"id"`
+	Key              string            `json:"key" yaml:"key"`
+	Name             string            `json:"name" yaml:"name"`
+	URL              string            `json:"url" yaml:"url"`
+	Interval         uint64            `json:"interval" yaml:"interval"`
+	Email            string            `json:"email" yaml:"email"`
+	Active           bool              `json:"active" yaml:"active"`
+	Channels         []string          `json:"channels" yaml:"channels"`
+	Tags             []string          `json:"tags" yaml:"tags"`
+	GroupID          string            `json:"group_id" yaml:"group_id"`
+	Headers          map[string]string `json:"headers" yaml:"headers"`
+	Extract          string            `json:"extract" yaml:"extract"`
+	Ignore           []string          `json:"ignore" yaml:"ignore"`
+	Kind             string            `json:"kind" yaml:"kind"`
+	ExpectedStatus   []int             `json:"expected_status" yaml:"expected_status"`
+	MaxLatency       uint64            `json:"max_latency" yaml:"max_latency"`
+	FailureThreshold int               `json:"failure_threshold" yaml:"failure_threshold"`
+	Timeout          uint64            `json:"timeout" yaml:"timeout"`
+	Retries          int               `json:"retries" yaml:"retries"`
+	RetryBackoff     uint64            `json:"retry_backoff" yaml:"retry_backoff"`
+}
+
+// csvColumns are the columns of CSV files. Lists are separated by
+// semicolons inside their cell, except for the headers and the ignore
+// patterns, which can contain semicolons and are written as JSON.
+var csvColumns = []string{"id", "key", "name", "url", "interval", "email", "active", "channels", "tags", "group_id",
+	"headers", "extract", "ignore", "kind", "expected_status", "max_latency", "failure_threshold", "timeout", "retries", "retry_backoff"}
+
+var transferContentTypes = map[string]string{
+	"json": "application/json; charset=utf-8",
+	"yaml": "application/yaml; charset=utf-8",
+	"csv":  "text/csv; charset=utf-8",
+}
+
+// newCheckRecord returns the record of a check. Empty lists and maps and
+// the fields left to their default are normalised, so that the records of
+// equivalent checks are equal.
+func newCheckRecord(check *models.Check) checkRecord {
+	record := checkRecord{
+		ID:               check.ID,
+		Key:              check.Key,
+		Name:             check.Name,
+		URL:              check.URL,
+		Interval:         check.Interval,
+		Email:            check.Email,
+		Active:           check.Active,
+		Channels:         sortedCopy(check.Channels),
+		Tags:             sortedCopy(check.Tags),
+		Extract:          check.Extract,
+		Kind:             check.Kind,
+		MaxLatency:       check.MaxLatency,
+		FailureThreshold: check.FailureThreshold,
+		Timeout:          check.Timeout,
+		Retries:          check.Retries,
+		RetryBackoff:     check.RetryBackoff,
+	}
+	if check.GroupID != nil {
+		record.GroupID = *check.GroupID
+	}
+	if len(check.Headers) > 0 {
+		record.Headers = check.Headers
+	}
+	if len(check.Ignore) > 0 {
+		record.Ignore = check.Ignore
+	}
+	if len(check.ExpectedStatus) > 0 {
+		record.ExpectedStatus = check.ExpectedStatus
+	}
+	if record.Kind == "" {
+		record.Kind = models.KindContent
+	}
+	if record.FailureThreshold == 0 {
+		record.FailureThreshold = models.DefaultFailureThreshold
+	}
+	return record
+}
+
+func (c *checkRecord) check() models.Check {
+	check := models.Check{
+		ID:               c.ID,
+		Key:              c.Key,
+		Name:             c.Name,
+		URL:              c.URL,
+		Interval:         c.Interval,
+		Email:            c.Email,
+		Active:           c.Active,
+		Channels:         sortedCopy(c.Channels),
+		Tags:             sortedCopy(c.Tags),
+		Headers:          c.Headers,
+		Extract:          c.Extract,
+		Ignore:           c.Ignore,
+		Kind:             c.Kind,
+		ExpectedStatus:   c.ExpectedStatus,
+		MaxLatency:       c.MaxLatency,
+		FailureThreshold: c.FailureThreshold,
+		Timeout:          c.Timeout,
+		Retries:          c.Retries,
+		RetryBackoff:     c.RetryBackoff,
+	}
+	if c.GroupID != "" {
+		groupID := c.GroupID
+		check.GroupID = &groupID
+	}
+	return check
+}
+
+func sortedCopy(values []string) []string {
+	sorted := make([]string, len(values))
+	copy(sorted, values)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func transferFormat(r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	_, ok := transferContentTypes[format]
+	return format, ok
+}
+
+// ExportChecks writes every check of the organisation as a JSON, YAML or
+// CSV file, depending on the format query parameter.
+func (h *StorageHandler) ExportChecks(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	orgID, ok := h.authorize(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	format, ok := transferFormat(r)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		problem(w, r, http.StatusBadRequest, "The format must be json, yaml or csv")
+		return
+	}
+
+	checks, err := h.Storage.GetChecks(r.Context(), models.CheckFilter{OrgID: orgID})
+	if err != nil {
+		h.Logger.Errorf("get checks: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	records := make([]checkRecord, len(checks))
+	for i := range checks {
+		records[i] = newCheckRecord(&checks[i])
+	}
+
+	w.Header().Set("Content-Type", transferContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="checks.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	switch format {
+	case "json":
+		err = json.NewEncoder(w).Encode(&records)
+	case "yaml":
+		err = yaml.NewEncoder(w).Encode(&records)
+	case "csv":
+		err = writeCSV(w, records)
+	}
+	if err != nil {
+		h.Logger.Errorf("export: %v", err)
+	}
+}
+
+func writeCSV(w io.Writer, records []checkRecord) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvColumns)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		var headers, ignore []byte
+		if len(record.Headers) > 0 {
+			headers, _ = json.Marshal(record.Headers)
+		}
+		if len(record.Ignore) > 0 {
+			ignore, _ = json.Marshal(record.Ignore)
+		}
+		statuses := make([]string, len(record.ExpectedStatus))
+		for i, code := range record.ExpectedStatus {
+			statuses[i] = strconv.Itoa(code)
+		}
+
+		err = cw.Write([]string{
+			record.ID,
+			record.Key,
+			record.Name,
+			record.URL,
+			strconv.FormatUint(record.Interval, 10),
+			record.Email,
+			strconv.FormatBool(record.Active),
+			strings.Join(record.Channels, ";"),
+			strings.Join(record.Tags, ";"),
+			record.GroupID,
+			string(headers),
+			record.Extract,
+			string(ignore),
+			record.Kind,
+			strings.Join(statuses, ";"),
+			strconv.FormatUint(record.MaxLatency, 10),
+			strconv.Itoa(record.FailureThreshold),
+			strconv.FormatUint(record.Timeout, 10),
+			strconv.Itoa(record.Retries),
+			strconv.FormatUint(record.RetryBackoff, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// importRow is a decoded row of an imported file with the errors that
+// prevent it from being imported.
+type importRow struct {
+	record checkRecord
+	errors []FieldError
+}
+
+func readRows(format string, body io.Reader) ([]importRow, error) {
+	var records []checkRecord
+	var err error
+
+	switch format {
+	case "json":
+		err = json.NewDecoder(body).Decode(&records)
+	case "yaml":
+		err = yaml.NewDecoder(body).Decode(&records)
+	case "csv":
+		return readCSV(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]importRow, len(records))
+	for i := range records {
+		rows[i].record = records[i]
+	}
+	return rows, nil
+}
+
+func readCSV(body io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(body)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for name := range columns {
+		if !contains(csvColumns, name) {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+	}
+
+	var rows []importRow
+	for {
+		cells, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var row importRow
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(cells) {
+				return strings.TrimSpace(cells[i])
+			}
+			return ""
+		}
+		list := func(name string) []string {
+			if cell(name) == "" {
+				return nil
+			}
+			return strings.Split(cell(name), ";")
+		}
+		number := func(name string) uint64 {
+			raw := cell(name)
+			if raw == "" {
+				return 0
+			}
+			n, err := strconv.ParseUint(raw, 10, 31)
+			if err != nil {
+				row.errors = append(row.errors, FieldError{Field: name, Rule: "number"})
+			}
+			return n
+		}
+		decode := func(name string, value interface{}) {
+			if raw := cell(name); raw != "" && json.Unmarshal([]byte(raw), value) != nil {
+				row.errors = append(row.errors, FieldError{Field: name, Rule: "json"})
+			}
+		}
+
+		row.record = checkRecord{
+			ID:               cell("id"),
+			Key:              cell("key"),
+			Name:             cell("name"),
+			URL:              cell("url"),
+			Email:            cell("email"),
+			Channels:         list("channels"),
+			Tags:             list("tags"),
+			GroupID:          cell("group_id"),
+			Extract:          cell("extract"),
+			Kind:             cell("kind"),
+			MaxLatency:       number("max_latency"),
+			FailureThreshold: int(number("failure_threshold")),
+			Timeout:          number("timeout"),
+			Retries:          int(number("retries")),
+			RetryBackoff:     number("retry_backoff"),
+		}
+		decode("headers", &row.record.Headers)
+		decode("ignore", &row.record.Ignore)
+
+		for _, raw := range list("expected_status") {
+			code, err := strconv.Atoi(strings.TrimSpace(raw))
+			if err != nil {
+				row.errors = append(row.errors, FieldError{Field: "expected_status", Rule: "number"})
+				break
+			}
+			row.record.ExpectedStatus = append(row.record.ExpectedStatus, code)
+		}
+
+		if raw := cell("interval"); raw != "" {
+			row.record.Interval, err = strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				row.errors = append(row.errors, FieldError{Field: "interval", Rule: "number"})
+			}
+		}
+
+		if raw := cell("active"); raw != "" {
+			row.record.Active, err = strconv.ParseBool(raw)
+			if err != nil {
+				row.errors = append(row.errors, FieldError{Field: "active", Rule: "boolean"})
+			}
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	importCreate = "create"
+	importUpdate = "update"
+	importSkip   = "skip"
+	importError  = "error"
+)
+
+type importResult struct {
+	// Row is the 1-based position of the check in the file.
+	Row    int          `json:"row"`
+	ID     string       `json:"id"`
+	Name   string       `json:"name"`
+	Action string       `json:"action"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type importReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Skipped int            `json:"skipped"`
+	Failed  int            `json:"failed"`
+	Rows    []importResult `json:"rows"`
+}
+
+// ImportChecks creates or updates the checks listed in a JSON, YAML or CSV
+// file. Checks whose ID exists in the organisation are updated, unless they
+// are unchanged, and the other ones are created. Nothing is saved when
+// dry_run is set or when any row is invalid, in which case the report lists
+// the errors of every row.
+func (h *StorageHandler) ImportChecks(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	orgID, ok := h.authorize(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	format, ok := transferFormat(r)
+	if !ok {
+		problem(w, r, http.StatusBadRequest, "The format must be json, yaml or csv")
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	rows, err := readRows(format, http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		h.Logger.Error("decode: ", err)
+		problem(w, r, http.StatusBadRequest, fmt.Sprintf("The file is not valid %s: %v", format, err))
+		return
+	}
+
+	plan, err := h.planImport(r, orgID, rows)
+	if err != nil {
+		h.Logger.Errorf("plan import: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	report := importReport{DryRun: dryRun, Rows: make([]importResult, len(plan))}
+	for i, step := range plan {
+		report.Rows[i] = step.result
+		switch step.result.Action {
+		case importCreate:
+			report.Created++
+		case importUpdate:
+			report.Updated++
+		case importSkip:
+			report.Skipped++
+		case importError:
+			report.Failed++
+		}
+	}
+
+	if dryRun || report.Failed > 0 {
+		if report.Failed > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		json.NewEncoder(w).Encode(&report)
+		return
+	}
+
+	// The whole file is applied in one transaction, and the events are only
+	// published once it is committed.
+	type published struct {
+		action string
+		step   *importStep
+		after  *models.Check
+	}
+	var events []published
+	var row int
+	err = h.Storage.Atomic(r.Context(), func(ctx context.Context) error {
+		events = events[:0]
+		for i := range plan {
+			step := &plan[i]
+			check := &step.check
+			row = step.result.Row
+			switch step.result.Action {
+			case importCreate:
+				err := h.Storage.CreateCheck(ctx, check)
+				if err != nil {
+					return err
+				}
+				err = h.audit(ctx, r, orgID, "create", auditCheck, check.ID, nil, check)
+				if err != nil {
+					return err
+				}
+				events = append(events, published{"create", step, check})
+			case importUpdate:
+				updated, err := h.Storage.UpdateCheck(ctx, orgID, check.ID, fullUpdate(check))
+				if err != nil {
+					return err
+				}
+				action := checkUpdateAction(step.before, &updated)
+				err = h.audit(ctx, r, orgID, action, auditCheck, check.ID, step.before, &updated)
+				if err != nil {
+					return err
+				}
+				events = append(events, published{action, step, &updated})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.Logger.Errorf("import row %d: %v", row, err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	for _, event := range events {
+		h.publishCheck(orgID, event.action, event.step.check.ID, event.step.before, event.after)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&report)
+}
+
+// importStep is what an import does with a row. Before is the check being
+// updated, if any.
+type importStep struct {
+	check  models.Check
+	before *models.Check
+	result importResult
+}
+
+// planImport validates every row and decides what to do with it.
+func (h *StorageHandler) planImport(r *http.Request, orgID string, rows []importRow) ([]importStep, error) {
+	existing, err := h.Storage.GetChecks(r.Context(), models.CheckFilter{OrgID: orgID})
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.Check, len(existing))
+	keys := make(map[string]string, len(existing))
+	for i := range existing {
+		byID[existing[i].ID] = &existing[i]
+		if existing[i].Key != "" {
+			keys[existing[i].Key] = existing[i].ID
+		}
+	}
+
+	channels, err := h.Storage.GetChannels(r.Context(), orgID)
+	if err != nil {
+		return nil, err
+	}
+	channelIDs := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		channelIDs[channel.ID] = true
+	}
+
+	groups, err := h.Storage.GetGroups(r.Context(), orgID)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		groupIDs[group.ID] = true
+	}
+
+	v := newValidator()
+	seen := make(map[string]bool, len(rows))
+	seenKeys := make(map[string]bool, len(rows))
+	plan := make([]importStep, len(rows))
+
+	for i, row := range rows {
+		check := row.record.check()
+		check.OrgID = orgID
+		errs := row.errors
+
+		if err := v.Struct(check); err != nil {
+			errs = append(errs, fieldErrors(err)...)
+		}
+		for _, id := range check.Channels {
+			if !channelIDs[id] {
+				errs = append(errs, FieldError{Field: "channels", Rule: "exists", Param: id})
+			}
+		}
+		if check.GroupID != nil && !groupIDs[*check.GroupID] {
+			errs = append(errs, FieldError{Field: "group_id", Rule: "exists", Param: *check.GroupID})
+		}
+		// The rules are compiled as when a check is created, one pattern at
+		// a time to report the invalid ones.
+		if _, err := extract.Compile(&models.Check{Extract: check.Extract}); err != nil {
+			errs = append(errs, FieldError{Field: "extract", Rule: "regexp", Param: check.Extract})
+		}
+		for _, pattern := range check.Ignore {
+			if _, err := extract.Compile(&models.Check{Ignore: models.Patterns{pattern}}); err != nil {
+				errs = append(errs, FieldError{Field: "ignore", Rule: "regexp", Param: pattern})
+			}
+		}
+		if check.ID != "" && seen[check.ID] {
+			errs = append(errs, FieldError{Field: "id", Rule: "unique", Param: check.ID})
+		}
+		seen[check.ID] = true
+		if check.Key != "" {
+			// Keys can't be taken from another check, even one that is
+			// updated by the same file.
+			if id, ok := keys[check.Key]; seenKeys[check.Key] || (ok && id != check.ID) {
+				errs = append(errs, FieldError{Field: "key", Rule: "unique", Param: check.Key})
+			}
+			seenKeys[check.Key] = true
+		}
+
+		result := importResult{Row: i + 1, ID: check.ID, Name: check.Name, Errors: errs}
+		current, exists := byID[check.ID]
+		switch {
+		case len(errs) > 0:
+			result.Action = importError
+		case !exists:
+			result.Action = importCreate
+			check.ID = uuid.NewString()
+			result.ID = check.ID
+		case reflect.DeepEqual(newCheckRecord(current), newCheckRecord(&check)):
+			result.Action = importSkip
+		default:
+			result.Action = importUpdate
+		}
+
+		plan[i] = importStep{check: check, before: current, result: result}
+	}
+
+	return plan, nil
+}
+
+// fullUpdate returns an update setting every field of a check.
+func fullUpdate(check *models.Check) *models.CheckUpdate {
+	groupID := ""
+	if check.GroupID != nil {
+		groupID = *check.GroupID
+	}
+	kind := check.Kind
+	if kind == "" {
+		kind = models.KindContent
+	}
+	headers := check.Headers
+	if headers == nil {
+		headers = make(models.Headers)
+	}
+	ignore := check.Ignore
+	if ignore == nil {
+		ignore = make(models.Patterns, 0)
+	}
+	expected := check.ExpectedStatus
+	if expected == nil {
+		expected = make(models.StatusCodes, 0)
+	}
+	return &models.CheckUpdate{
+		Key:              &check.Key,
+		URL:              &check.URL,
+		Name:             &check.Name,
+		Interval:         &check.Interval,
+		Email:            &check.Email,
+		Active:           &check.Active,
+		Channels:         &check.Channels,
+		Tags:             &check.Tags,
+		GroupID:          &groupID,
+		Headers:          &headers,
+		Extract:          &check.Extract,
+		Ignore:           &ignore,
+		Kind:             &kind,
+		ExpectedStatus:   &expected,
+		MaxLatency:       &check.MaxLatency,
+		FailureThreshold: &check.FailureThreshold,
+		Timeout:          &check.Timeout,
+		Retries:          &check.Retries,
+		RetryBackoff:     &check.RetryBackoff,
+	}
+}
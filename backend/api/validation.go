@@ -0,0 +1,52 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/samirettali/webmonitor/apitypes"
+)
+
+// FieldError describes a field that failed validation, see
+// apitypes.FieldError.
+type FieldError = apitypes.FieldError
+
+// newValidator returns a validator that names fields after their JSON keys.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// fieldErrors turns the error returned by a validator into a list of field
+// errors. Errors that don't come from the validator are reported on an
+// empty field.
+func fieldErrors(err error) []FieldError {
+	verrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []FieldError{{Rule: err.Error()}}
+	}
+
+	errs := make([]FieldError, len(verrs))
+	for i, ferr := range verrs {
+		// The namespace starts with the struct name, which is not part of
+		// the JSON representation.
+		field := ferr.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		errs[i] = FieldError{
+			Field: field,
+			Rule:  ferr.Tag(),
+			Param: ferr.Param(),
+		}
+	}
+	return errs
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/samirettali/webmonitor/auth"
+	"github.com/samirettali/webmonitor/events"
+)
+
+const (
+	// socketWriteTimeout bounds the time to write a message to a client.
+	socketWriteTimeout = 10 * time.Second
+	// socketPongTimeout is how long a client can stay silent, it is pinged
+	// at a shorter interval.
+	socketPongTimeout  = 60 * time.Second
+	socketPingInterval = socketPongTimeout * 9 / 10
+	// outboxSize is the number of messages waiting for a slow client
+	// beyond which the oldest ones are dropped.
+	outboxSize = 256
+)
+
+// socketRequest is a message sent by a client to change its subscriptions.
+type socketRequest struct {
+	Type   string   `json:"type"`
+	Checks []string `json:"checks"`
+	Tags   []string `json:"tags"`
+}
+
+// socketMessage is a message sent to a client besides events. Subscribed
+// acknowledges a request with the resulting subscriptions, Dropped counts
+// the events a slow client missed.
+type socketMessage struct {
+	Type    string   `json:"type"`
+	Checks  []string `json:"checks,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Dropped int      `json:"dropped,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// socketFilter is the set of checks and tags a client is subscribed to.
+type socketFilter struct {
+	mu     sync.Mutex
+	orgs   map[string]bool
+	checks map[string]bool
+	tags   map[string]bool
+}
+
+func (f *socketFilter) update(req *socketRequest) socketMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscribe := req.Type == "subscribe"
+	for _, id := range req.Checks {
+		setMember(f.checks, id, subscribe)
+	}
+	for _, tag := range req.Tags {
+		setMember(f.tags, tag, subscribe)
+	}
+
+	return socketMessage{Type: "subscribed", Checks: members(f.checks), Tags: members(f.tags)}
+}
+
+func (f *socketFilter) match(e *events.Event) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.orgs[e.OrgID] {
+		return false
+	}
+	if f.checks[e.CheckID] {
+		return true
+	}
+	for _, tag := range e.Tags {
+		if f.tags[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+func setMember(set map[string]bool, key string, member bool) {
+	if member {
+		set[key] = true
+	} else {
+		delete(set, key)
+	}
+}
+
+func members(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// outboxItem is a message waiting to be written. Items with the same
+// non-empty key are coalesced, only the latest one is kept.
+type outboxItem struct {
+	key string
+	msg interface{}
+}
+
+// outbox queues the messages of a client so that a slow one never holds
+// back the bus. Runs and updates of a check replace the pending ones, since
+// only the latest state matters, and beyond outboxSize the oldest messages
+// are dropped.
+type outbox struct {
+	mu      sync.Mutex
+	items   []outboxItem
+	dropped int
+	ready   chan struct{}
+}
+
+func newOutbox() *outbox {
+	return &outbox{ready: make(chan struct{}, 1)}
+}
+
+func (o *outbox) push(item outboxItem) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if item.key != "" {
+		for i := range o.items {
+			if o.items[i].key == item.key {
+				o.items = append(o.items[:i], o.items[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(o.items) >= outboxSize {
+		o.items = o.items[1:]
+		o.dropped++
+	}
+	o.items = append(o.items, item)
+
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take empties the outbox, returning its items and how many were dropped.
+func (o *outbox) take() ([]outboxItem, int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	items, dropped := o.items, o.dropped
+	o.items, o.dropped = nil, 0
+	return items, dropped
+}
+
+func pushEvent(o *outbox, e events.Event) {
+	var key string
+	if e.Type == events.CheckRun || e.Type == events.CheckUpdated {
+		key = e.Type + " " + e.CheckID
+	}
+	o.push(outboxItem{key: key, msg: e})
+}
+
+// Subscribe upgrades the request to a WebSocket streaming the events of
+// the checks the client subscribes to, by ID or by tag, in the
+// organisations of the user. Clients send
+// {"type": "subscribe"|"unsubscribe", "checks": [...], "tags": [...]} and
+// receive the events as JSON, as well as the resulting subscriptions after
+// every request.
+func (h *StorageHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		problem(w, r, http.StatusUnauthorized, "The request is not authenticated")
+		return
+	}
+
+	if h.Events == nil {
+		problem(w, r, http.StatusServiceUnavailable, "Events are not enabled")
+		return
+	}
+
+	orgs, err := h.userOrgs(r, user)
+	if err != nil {
+		h.Logger.Errorf("get memberships: %v", err)
+		problem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered with an error.
+		return
+	}
+	defer conn.Close()
+
+	filter := &socketFilter{orgs: orgs, checks: make(map[string]bool), tags: make(map[string]bool)}
+	out := newOutbox()
+	done := make(chan struct{})
+	defer close(done)
+
+	sub, _ := h.Events.Subscribe(0)
+	defer h.Events.Unsubscribe(sub)
+
+	// The subscription is drained as fast as possible, backpressure is
+	// handled by the outbox. It is only closed by the bus if the client
+	// disconnected, or when unsubscribing.
+	go func() {
+		defer conn.Close()
+		for e := range sub.C {
+			if filter.match(&e) {
+				pushEvent(out, e)
+			}
+		}
+	}()
+
+	go h.writeSocket(conn, out, done)
+
+	conn.SetReadLimit(4096)
+	conn.SetReadDeadline(time.Now().Add(socketPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketPongTimeout))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req socketRequest
+		err = json.Unmarshal(data, &req)
+		if err != nil {
+			out.push(outboxItem{msg: socketMessage{Type: "error", Error: "The message is not valid JSON"}})
+			continue
+		}
+		if req.Type != "subscribe" && req.Type != "unsubscribe" {
+			out.push(outboxItem{msg: socketMessage{Type: "error", Error: "The type must be subscribe or unsubscribe"}})
+			continue
+		}
+		out.push(outboxItem{msg: filter.update(&req)})
+	}
+}
+
+// writeSocket writes the messages of an outbox to a connection and pings it
+// until done is closed or a write fails, closing the connection so that
+// the reader stops too.
+func (h *StorageHandler) writeSocket(conn *websocket.Conn, out *outbox, done <-chan struct{}) {
+	defer conn.Close()
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-out.ready:
+			items, dropped := out.take()
+			if dropped > 0 {
+				items = append([]outboxItem{{msg: socketMessage{Type: "dropped", Dropped: dropped}}}, items...)
+			}
+			for _, item := range items {
+				conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+				if err := conn.WriteJSON(item.msg); err != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout))
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// checkOrigin accepts WebSockets opened by pages of the server itself or of
+// one of the allowed origins, since browsers send the session cookie
+// whichever page opens them.
+func (h *StorageHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.Origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,59 @@
+// Package apitypes holds what the server and the clients of the REST API
+// share: its paths, headers and error bodies. It only depends on the
+// standard library so that clients don't pull in the server.
+package apitypes
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// V1Prefix is the path version 1 of the API is served under.
+const V1Prefix = "/api/v1"
+
+// OrgHeader selects the organisation a request operates on when the route
+// does not name one. Without it the first organisation the user joined is
+// used.
+const OrgHeader = "X-Organisation"
+
+// NextCursorHeader carries the cursor of the next page of a paginated
+// listing. It is missing on the last page.
+const NextCursorHeader = "X-Next-Cursor"
+
+// ProblemContentType is the media type of the error bodies described in
+// RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 error body. Errors lists the invalid fields of the
+// request, if any.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes a field that failed validation. Field is the name of
+// the field in the JSON representation and Rule the failed validator tag.
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// WriteProblem writes an error response with a problem body describing
+// the request r.
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, detail string, errs ...FieldError) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Errors:   errs,
+	})
+}
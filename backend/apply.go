@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/samirettali/webmonitor/config"
+	"github.com/samirettali/webmonitor/logger"
+	"github.com/samirettali/webmonitor/storage"
+)
+
+// runApply implements `webmonitor apply -f checks.yaml`, which makes an
+// organisation match a configuration file, and returns the exit code.
+func runApply(args []string, store storage.Storage, log logger.Logger) int {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	path := flags.String("f", "", "configuration file")
+	orgID := flags.String("org", "", "organisation, overriding the one of the file")
+	prune := flags.Bool("prune", false, "delete the checks, channels and tags missing from the file")
+	dryRun := flags.Bool("dry-run", false, "print the changes without making them")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "apply: the -f flag is required")
+		flags.Usage()
+		return 2
+	}
+
+	file, err := config.Load(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "apply:", err)
+		return 1
+	}
+
+	if *orgID == "" {
+		*orgID = file.Organisation
+	}
+	if *orgID == "" {
+		fmt.Fprintln(os.Stderr, "apply: the organisation must be set in the file or with -org")
+		return 2
+	}
+
+	if err := store.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, "apply:", err)
+		return 1
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	plan, err := config.NewPlan(ctx, store, log, *orgID, file, *prune)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "apply:", err)
+		return 1
+	}
+
+	if len(plan.Changes) == 0 {
+		fmt.Println("Nothing to change")
+		return 0
+	}
+	for i := range plan.Changes {
+		fmt.Println(plan.Changes[i].String())
+	}
+
+	if *dryRun {
+		fmt.Printf("%d changes, none applied\n", len(plan.Changes))
+		return 0
+	}
+
+	err = plan.Apply(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "apply:", err)
+		return 1
+	}
+	fmt.Printf("%d changes applied\n", len(plan.Changes))
+	return 0
+}
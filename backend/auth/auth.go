@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/samirettali/webmonitor/apitypes"
+	"github.com/samirettali/webmonitor/logger"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/storage"
+)
+
+type contextKey struct{}
+
+// WithUser returns a copy of ctx carrying the authenticated user.
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext returns the authenticated user stored in ctx, if any.
+func UserFromContext(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(contextKey{}).(*models.User)
+	return user, ok
+}
+
+// NewAPIKey generates a random API key and returns it together with the
+// hash that has to be stored.
+func NewAPIKey() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	key := hex.EncodeToString(buf)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the representation of an API key that is persisted.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticator resolves the user making a request from its bearer token.
+type Authenticator struct {
+	Storage storage.Storage
+	Logger  logger.Logger
+}
+
+// Middleware rejects requests without valid credentials and stores the
+// authenticated user in the request context. Credentials are either an API
+// key or a single sign-on session, sent as a bearer token or, for browsers,
+// in the session cookie.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := bearerToken(r)
+		if token == "" {
+			if cookie, err := r.Cookie(SessionCookie); err == nil {
+				token = cookie.Value
+			}
+		}
+		if token == "" {
+			apitypes.WriteProblem(w, r, http.StatusUnauthorized, "The request has no API key or session")
+			return
+		}
+
+		user, err := a.Storage.GetUserByAPIKey(r.Context(), HashAPIKey(token))
+		if err == sql.ErrNoRows {
+			user, err = a.Storage.GetUserBySession(r.Context(), HashAPIKey(token))
+		}
+		if err == sql.ErrNoRows {
+			apitypes.WriteProblem(w, r, http.StatusUnauthorized, "The API key or session is not valid")
+			return
+		}
+		if err != nil {
+			a.Logger.Errorf("authenticate: %v", err)
+			apitypes.WriteProblem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), &user)))
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// Bootstrap makes sure that an administrator identified by email and apiKey
+// exists and owns a default organisation, which also receives the checks
+// created before organisations were introduced.
+func Bootstrap(ctx context.Context, store storage.Storage, email string, apiKey string) error {
+	user, err := store.GetUserByEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		user = models.User{
+			ID:         uuid.NewString(),
+			Email:      email,
+			APIKeyHash: HashAPIKey(apiKey),
+			Created:    time.Now(),
+		}
+		err = store.CreateUser(ctx, &user)
+	} else if err == nil && user.APIKeyHash != HashAPIKey(apiKey) {
+		err = store.SetUserAPIKey(ctx, user.ID, HashAPIKey(apiKey))
+	}
+	if err != nil {
+		return errors.Wrap(err, "can't create admin user")
+	}
+
+	orgs, err := store.GetOrganisations(ctx, user.ID)
+	if err != nil {
+		return errors.Wrap(err, "can't get organisations")
+	}
+
+	var org models.Organisation
+	if len(orgs) > 0 {
+		org = orgs[0]
+	} else {
+		org = models.Organisation{
+			ID:      uuid.NewString(),
+			Name:    "Default",
+			Created: time.Now(),
+		}
+		err = store.CreateOrganisation(ctx, &org, user.ID)
+		if err != nil {
+			return errors.Wrap(err, "can't create default organisation")
+		}
+	}
+
+	return store.AdoptChecks(ctx, org.ID)
+}
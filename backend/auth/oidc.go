@@ -0,0 +1,430 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/samirettali/webmonitor/apitypes"
+	"github.com/samirettali/webmonitor/logger"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/storage"
+	"golang.org/x/oauth2"
+)
+
+// SessionCookie is the cookie holding the session token of browsers that
+// logged in through single sign-on.
+const SessionCookie = "webmonitor_session"
+
+// loginTimeout is how long a user has to complete the login on the IdP.
+const loginTimeout = 10 * time.Minute
+
+// GroupRole grants Role in the organisation OrgID to the members of Group.
+type GroupRole struct {
+	Group string
+	OrgID string
+	Role  models.Role
+}
+
+// ParseGroupRoles parses a comma separated list of group=org:role mappings.
+func ParseGroupRoles(s string) ([]GroupRole, error) {
+	var mappings []GroupRole
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		group, target, ok := strings.Cut(entry, "=")
+		orgID, role, ok2 := strings.Cut(target, ":")
+		if !ok || !ok2 || group == "" || orgID == "" {
+			return nil, fmt.Errorf("invalid group mapping %q", entry)
+		}
+		if !models.Role(role).Allows(models.RoleViewer) {
+			return nil, fmt.Errorf("invalid role %q in group mapping %q", role, entry)
+		}
+
+		mappings = append(mappings, GroupRole{Group: group, OrgID: orgID, Role: models.Role(role)})
+	}
+	return mappings, nil
+}
+
+type OIDCConfig struct {
+	// Issuer is the URL the discovery document is fetched from.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL of the callback endpoint.
+	RedirectURL string
+	// GroupsClaim is the claim listing the groups of the user, if any.
+	GroupsClaim string
+	GroupRoles  []GroupRole
+	// AfterLogin is where the browser is sent once logged in.
+	AfterLogin string
+	SessionTTL time.Duration
+	// Client is used to talk to the IdP, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+type pendingLogin struct {
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+// OIDC logs users in with the authorization code flow and PKCE, turning
+// the email claim of the ID token into a user and a session.
+type OIDC struct {
+	Storage storage.Storage
+	Logger  logger.Logger
+
+	config   OIDCConfig
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+
+	sync.Mutex
+	pending map[string]pendingLogin
+}
+
+// NewOIDC fetches the discovery document of the issuer and prepares the
+// verification of the ID tokens it signs.
+func NewOIDC(ctx context.Context, config OIDCConfig, store storage.Storage, logger logger.Logger) (*OIDC, error) {
+	if config.Client != nil {
+		ctx = oidc.ClientContext(ctx, config.Client)
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't discover provider")
+	}
+
+	if config.SessionTTL == 0 {
+		config.SessionTTL = 24 * time.Hour
+	}
+	if config.AfterLogin == "" {
+		config.AfterLogin = "/"
+	}
+
+	return &OIDC{
+		Storage: store,
+		Logger:  logger,
+		config:  config,
+		oauth: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		pending:  make(map[string]pendingLogin),
+	}, nil
+}
+
+func (o *OIDC) context(ctx context.Context) context.Context {
+	if o.config.Client != nil {
+		return oidc.ClientContext(ctx, o.config.Client)
+	}
+	return ctx
+}
+
+// Login redirects the browser to the IdP.
+func (o *OIDC) Login(w http.ResponseWriter, r *http.Request) {
+	state, err := randomString()
+	if err != nil {
+		o.Logger.Errorf("generate state: %v", err)
+		apitypes.WriteProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		o.Logger.Errorf("generate nonce: %v", err)
+		apitypes.WriteProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	o.Lock()
+	now := time.Now()
+	for s, p := range o.pending {
+		if now.After(p.expires) {
+			delete(o.pending, s)
+		}
+	}
+	o.pending[state] = pendingLogin{
+		verifier: verifier,
+		nonce:    nonce,
+		expires:  now.Add(loginTimeout),
+	}
+	o.Unlock()
+
+	url := o.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// Callback completes the login started by Login and starts a session.
+func (o *OIDC) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	o.Lock()
+	login, ok := o.pending[query.Get("state")]
+	delete(o.pending, query.Get("state"))
+	o.Unlock()
+
+	if !ok || time.Now().After(login.expires) {
+		apitypes.WriteProblem(w, r, http.StatusBadRequest, "The login is unknown or expired")
+		return
+	}
+
+	if e := query.Get("error"); e != "" {
+		o.Logger.Errorf("oidc login: %s: %s", e, query.Get("error_description"))
+		apitypes.WriteProblem(w, r, http.StatusUnauthorized, "The identity provider didn't authenticate the user")
+		return
+	}
+
+	ctx := o.context(r.Context())
+	token, err := o.oauth.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		o.Logger.Errorf("oidc exchange: %v", err)
+		apitypes.WriteProblem(w, r, http.StatusUnauthorized, "The identity provider didn't authenticate the user")
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		o.Logger.Error("oidc exchange: no id_token in response")
+		apitypes.WriteProblem(w, r, http.StatusUnauthorized, "The identity provider didn't authenticate the user")
+		return
+	}
+
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		o.Logger.Errorf("oidc verify: %v", err)
+		apitypes.WriteProblem(w, r, http.StatusUnauthorized, "The identity provider didn't authenticate the user")
+		return
+	}
+
+	if idToken.Nonce != login.nonce {
+		o.Logger.Error("oidc verify: nonce mismatch")
+		apitypes.WriteProblem(w, r, http.StatusUnauthorized, "The identity provider didn't authenticate the user")
+		return
+	}
+
+	var claims map[string]interface{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		o.Logger.Errorf("oidc claims: %v", err)
+		apitypes.WriteProblem(w, r, http.StatusUnauthorized, "The identity provider didn't authenticate the user")
+		return
+	}
+
+	email, _ := claims["email"].(string)
+	if verified, ok := claims["email_verified"].(bool); email == "" || (ok && !verified) {
+		o.Logger.Errorf("oidc claims: missing or unverified email for %s", idToken.Subject)
+		apitypes.WriteProblem(w, r, http.StatusForbidden, "The identity provider didn't return a verified email")
+		return
+	}
+
+	user, err := o.userFor(r.Context(), email)
+	if err != nil {
+		o.Logger.Errorf("oidc user: %v", err)
+		apitypes.WriteProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	// A token without the groups claim, which IdPs leave out when it isn't
+	// requested or configured, leaves the memberships alone rather than
+	// removing the user from every mapped organisation.
+	if claim, ok := claims[o.config.GroupsClaim]; ok && o.config.GroupsClaim != "" {
+		err = o.applyGroups(r.Context(), user.ID, groups(claim))
+		if err != nil {
+			o.Logger.Errorf("oidc groups: %v", err)
+			apitypes.WriteProblem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	sessionToken, hash, err := NewAPIKey()
+	if err != nil {
+		o.Logger.Errorf("generate session: %v", err)
+		apitypes.WriteProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	session := models.Session{
+		TokenHash: hash,
+		UserID:    user.ID,
+		Expires:   time.Now().Add(o.config.SessionTTL),
+	}
+	err = o.Storage.CreateSession(r.Context(), &session)
+	if err != nil {
+		o.Logger.Errorf("create session: %v", err)
+		apitypes.WriteProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    sessionToken,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(o.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, o.config.AfterLogin, http.StatusFound)
+}
+
+// Logout ends the session of the browser.
+func (o *OIDC) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		err = o.Storage.DeleteSession(r.Context(), HashAPIKey(cookie.Value))
+		if err != nil {
+			o.Logger.Errorf("delete session: %v", err)
+			apitypes.WriteProblem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userFor returns the user with the given email, creating it on first
+// login. Users created here have no API key until they rotate one.
+func (o *OIDC) userFor(ctx context.Context, email string) (models.User, error) {
+	user, err := o.Storage.GetUserByEmail(ctx, email)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	user = models.User{
+		ID:      uuid.NewString(),
+		Email:   email,
+		Created: time.Now(),
+	}
+	return user, o.Storage.CreateUser(ctx, &user)
+}
+
+// applyGroups makes the memberships of the user in the organisations the
+// groups are mapped to match the groups they are in. When several groups
+// map to the same organisation the highest role wins, and the user is
+// removed from the mapped organisations none of their groups grant a role
+// in. Memberships in other organisations are left alone, and so is the
+// role of the last admin of an organisation.
+func (o *OIDC) applyGroups(ctx context.Context, userID string, userGroups []string) error {
+	roles := make(map[string]models.Role)
+	for _, mapping := range o.config.GroupRoles {
+		if _, ok := roles[mapping.OrgID]; !ok {
+			roles[mapping.OrgID] = ""
+		}
+		for _, group := range userGroups {
+			if group == mapping.Group && !roles[mapping.OrgID].Allows(mapping.Role) {
+				roles[mapping.OrgID] = mapping.Role
+			}
+		}
+	}
+
+	memberships, err := o.Storage.GetMemberships(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "can't get memberships")
+	}
+	current := make(map[string]models.Role, len(memberships))
+	for _, membership := range memberships {
+		current[membership.OrgID] = membership.Role
+	}
+
+	for orgID, role := range roles {
+		existing := current[orgID]
+		if role == existing {
+			continue
+		}
+		if existing == models.RoleAdmin {
+			last, err := o.lastAdmin(ctx, orgID, userID)
+			if err != nil {
+				return err
+			}
+			if last {
+				o.Logger.Warnf("oidc groups: keeping %s as the last admin of %s", userID, orgID)
+				continue
+			}
+		}
+
+		if role == "" {
+			err := o.Storage.DeleteMembership(ctx, orgID, userID)
+			if err != nil {
+				return errors.Wrapf(err, "can't remove user from %s", orgID)
+			}
+		} else {
+			membership := models.Membership{
+				OrgID:   orgID,
+				UserID:  userID,
+				Role:    role,
+				Created: time.Now(),
+			}
+			err := o.Storage.SetMembership(ctx, &membership)
+			if err != nil {
+				return errors.Wrapf(err, "can't add user to %s", orgID)
+			}
+		}
+	}
+	return nil
+}
+
+// lastAdmin reports whether a user is the only admin of an organisation.
+func (o *OIDC) lastAdmin(ctx context.Context, orgID string, userID string) (bool, error) {
+	members, err := o.Storage.GetMembers(ctx, orgID)
+	if err != nil {
+		return false, errors.Wrapf(err, "can't get members of %s", orgID)
+	}
+	for _, member := range members {
+		if member.UserID != userID && member.Role == models.RoleAdmin {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// groups reads a groups claim, which IdPs send either as a list or as a
+// single string.
+func groups(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	default:
+		return nil
+	}
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,462 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samirettali/webmonitor/apitypes"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const testClientID = "webmonitor"
+
+// mockProvider is an OpenID Connect provider serving the discovery
+// document, its keys and a token endpoint that checks the PKCE verifier.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	sync.Mutex
+	// codes are the authorization codes handed out by authorize, with the
+	// challenge of the login and the claims of its ID token.
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &mockProvider{key: key, codes: make(map[string]mockGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.keys)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *mockProvider) keys(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.Lock()
+	grant, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.sign(grant.claims),
+	})
+}
+
+// sign returns an RS256 JWT with the given claims.
+func (p *mockProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// memoryStorage keeps the users, sessions and memberships touched by a
+// login. The other methods of storage.Storage are not implemented.
+type memoryStorage struct {
+	storage.Storage
+
+	users       map[string]models.User
+	sessions    []models.Session
+	memberships map[string]models.Role
+	// admins are the organisations with another admin.
+	admins map[string]bool
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		users:       make(map[string]models.User),
+		memberships: make(map[string]models.Role),
+		admins:      make(map[string]bool),
+	}
+}
+
+func (s *memoryStorage) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	user, ok := s.users[email]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (s *memoryStorage) CreateUser(ctx context.Context, user *models.User) error {
+	s.users[user.Email] = *user
+	return nil
+}
+
+func (s *memoryStorage) CreateSession(ctx context.Context, session *models.Session) error {
+	s.sessions = append(s.sessions, *session)
+	return nil
+}
+
+func (s *memoryStorage) GetMemberships(ctx context.Context, userID string) ([]models.Membership, error) {
+	var memberships []models.Membership
+	for orgID, role := range s.memberships {
+		memberships = append(memberships, models.Membership{OrgID: orgID, UserID: userID, Role: role})
+	}
+	return memberships, nil
+}
+
+func (s *memoryStorage) GetMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	// The memberships are the ones of the only user.
+	var members []models.Membership
+	for _, user := range s.users {
+		if role, ok := s.memberships[orgID]; ok {
+			members = append(members, models.Membership{OrgID: orgID, UserID: user.ID, Role: role})
+		}
+	}
+	if s.admins[orgID] {
+		members = append(members, models.Membership{OrgID: orgID, UserID: "another", Role: models.RoleAdmin})
+	}
+	return members, nil
+}
+
+func (s *memoryStorage) SetMembership(ctx context.Context, membership *models.Membership) error {
+	s.memberships[membership.OrgID] = membership.Role
+	return nil
+}
+
+func (s *memoryStorage) DeleteMembership(ctx context.Context, orgID string, userID string) error {
+	delete(s.memberships, orgID)
+	return nil
+}
+
+type oidcTest struct {
+	provider *mockProvider
+	store    *memoryStorage
+	oidc     *OIDC
+}
+
+func newOIDCTest(t *testing.T, groupRoles []GroupRole) *oidcTest {
+	provider := newMockProvider(t)
+	store := newMemoryStorage()
+	o, err := NewOIDC(context.Background(), OIDCConfig{
+		Issuer:      provider.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://monitor.example.com/api/v1/auth/callback",
+		GroupsClaim: "groups",
+		GroupRoles:  groupRoles,
+		AfterLogin:  "/dashboard",
+	}, store, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &oidcTest{provider: provider, store: store, oidc: o}
+}
+
+// login goes through Login and Callback, with the IdP issuing an ID token
+// with the given claims on top of the standard ones. edit can change the
+// claims once the nonce of the login is known.
+func (c *oidcTest) login(t *testing.T, claims map[string]interface{}, edit func(claims map[string]interface{})) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	c.oidc.Login(rec, httptest.NewRequest(http.MethodGet, "/api/v1/auth/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login returned %d", rec.Code)
+	}
+
+	authorize, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authorize.Query()
+	if method := query.Get("code_challenge_method"); method != "S256" {
+		t.Fatalf("code_challenge_method is %q, want S256", method)
+	}
+
+	all := map[string]interface{}{
+		"iss":   c.provider.URL,
+		"aud":   testClientID,
+		"sub":   "user-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		all[name] = value
+	}
+	if edit != nil {
+		edit(all)
+	}
+
+	c.provider.Lock()
+	c.provider.codes["code-1"] = mockGrant{challenge: query.Get("code_challenge"), claims: all}
+	c.provider.Unlock()
+
+	callback := "/api/v1/auth/callback?" + url.Values{"state": {query.Get("state")}, "code": {"code-1"}}.Encode()
+	rec = httptest.NewRecorder()
+	c.oidc.Callback(rec, httptest.NewRequest(http.MethodGet, callback, nil))
+	return rec
+}
+
+func TestOIDCLogin(t *testing.T) {
+	c := newOIDCTest(t, nil)
+
+	rec := c.login(t, map[string]interface{}{"email": "ada@example.com", "email_verified": true}, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback returned %d, want %d", rec.Code, http.StatusFound)
+	}
+	if location := rec.Header().Get("Location"); location != "/dashboard" {
+		t.Errorf("redirected to %q, want /dashboard", location)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == SessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || !cookie.Secure {
+		t.Fatalf("session cookie = %+v, want a secure HTTP only cookie", cookie)
+	}
+
+	user, ok := c.store.users["ada@example.com"]
+	if !ok {
+		t.Fatal("the user was not created")
+	}
+	if len(c.store.sessions) != 1 || c.store.sessions[0].UserID != user.ID || c.store.sessions[0].TokenHash != HashAPIKey(cookie.Value) {
+		t.Errorf("sessions = %+v, want one for the cookie of %s", c.store.sessions, user.ID)
+	}
+}
+
+func TestOIDCPKCE(t *testing.T) {
+	c := newOIDCTest(t, nil)
+
+	// The IdP only accepts the verifier matching the challenge of the
+	// login, so a login whose verifier was swapped is rejected.
+	claims := map[string]interface{}{"email": "ada@example.com"}
+	rec := c.login(t, claims, func(map[string]interface{}) {
+		c.oidc.Lock()
+		defer c.oidc.Unlock()
+		for state, login := range c.oidc.pending {
+			login.verifier = "another-verifier-that-is-long-enough-for-pkce-000"
+			c.oidc.pending[state] = login
+		}
+	})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("callback returned %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if len(c.store.sessions) != 0 {
+		t.Error("a session was created")
+	}
+}
+
+func TestOIDCRejections(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		edit   func(claims map[string]interface{})
+		want   int
+	}{
+		{
+			name:   "nonce mismatch",
+			claims: map[string]interface{}{"email": "ada@example.com"},
+			edit:   func(claims map[string]interface{}) { claims["nonce"] = "another" },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong audience",
+			claims: map[string]interface{}{"email": "ada@example.com"},
+			edit:   func(claims map[string]interface{}) { claims["aud"] = "another" },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "unverified email",
+			claims: map[string]interface{}{"email": "ada@example.com", "email_verified": false},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "missing email",
+			claims: map[string]interface{}{},
+			want:   http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newOIDCTest(t, nil)
+			rec := c.login(t, test.claims, test.edit)
+			if rec.Code != test.want {
+				t.Fatalf("callback returned %d, want %d", rec.Code, test.want)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != apitypes.ProblemContentType {
+				t.Errorf("callback answered with %s, want a problem", ct)
+			}
+			if len(c.store.users) != 0 || len(c.store.sessions) != 0 {
+				t.Error("a user or a session was created")
+			}
+		})
+	}
+}
+
+func TestOIDCUnknownState(t *testing.T) {
+	c := newOIDCTest(t, nil)
+	rec := httptest.NewRecorder()
+	c.oidc.Callback(rec, httptest.NewRequest(http.MethodGet, "/api/v1/auth/callback?state=unknown&code=code-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback returned %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCGroupRoles(t *testing.T) {
+	c := newOIDCTest(t, []GroupRole{
+		{Group: "devs", OrgID: "org-1", Role: models.RoleViewer},
+		{Group: "ops", OrgID: "org-1", Role: models.RoleEditor},
+		{Group: "admins", OrgID: "org-2", Role: models.RoleAdmin},
+	})
+	// org-3 is not mapped to any group, so its membership is managed in
+	// the application only.
+	c.store.memberships["org-3"] = models.RoleAdmin
+	c.store.admins["org-2"] = true
+
+	steps := []struct {
+		groups interface{}
+		want   map[string]models.Role
+	}{
+		{
+			// The highest role granted in an organisation wins.
+			groups: []string{"devs", "ops", "admins"},
+			want:   map[string]models.Role{"org-1": models.RoleEditor, "org-2": models.RoleAdmin, "org-3": models.RoleAdmin},
+		},
+		{
+			// Leaving a group demotes the user, and leaving every group
+			// of an organisation removes them from it.
+			groups: "devs",
+			want:   map[string]models.Role{"org-1": models.RoleViewer, "org-3": models.RoleAdmin},
+		},
+		{
+			groups: []string{},
+			want:   map[string]models.Role{"org-3": models.RoleAdmin},
+		},
+	}
+
+	for i, step := range steps {
+		rec := c.login(t, map[string]interface{}{"email": "ada@example.com", "groups": step.groups}, nil)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("login %d: callback returned %d", i, rec.Code)
+		}
+		if len(c.store.memberships) != len(step.want) {
+			t.Errorf("login %d: memberships = %v, want %v", i, c.store.memberships, step.want)
+			continue
+		}
+		for orgID, role := range step.want {
+			if c.store.memberships[orgID] != role {
+				t.Errorf("login %d: memberships = %v, want %v", i, c.store.memberships, step.want)
+				break
+			}
+		}
+	}
+}
+
+func TestOIDCGroupRolesKeepAdmin(t *testing.T) {
+	c := newOIDCTest(t, []GroupRole{
+		{Group: "devs", OrgID: "org-1", Role: models.RoleViewer},
+		{Group: "admins", OrgID: "org-1", Role: models.RoleAdmin},
+	})
+
+	steps := []struct {
+		claims map[string]interface{}
+		want   models.Role
+	}{
+		{
+			claims: map[string]interface{}{"groups": []string{"admins"}},
+			want:   models.RoleAdmin,
+		},
+		{
+			// A token without the groups claim changes nothing.
+			claims: map[string]interface{}{},
+			want:   models.RoleAdmin,
+		},
+		{
+			// The last admin of an organisation keeps the role.
+			claims: map[string]interface{}{"groups": []string{"devs"}},
+			want:   models.RoleAdmin,
+		},
+	}
+
+	for i, step := range steps {
+		step.claims["email"] = "ada@example.com"
+		rec := c.login(t, step.claims, nil)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("login %d: callback returned %d", i, rec.Code)
+		}
+		if role := c.store.memberships["org-1"]; role != step.want {
+			t.Errorf("login %d: role %q, want %q", i, role, step.want)
+		}
+	}
+
+	// Once there is another admin the user can be demoted.
+	c.store.admins["org-1"] = true
+	rec := c.login(t, map[string]interface{}{"email": "ada@example.com", "groups": []string{"devs"}}, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback returned %d", rec.Code)
+	}
+	if role := c.store.memberships["org-1"]; role != models.RoleViewer {
+		t.Errorf("role %q, want %q", role, models.RoleViewer)
+	}
+}
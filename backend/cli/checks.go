@@ -0,0 +1,318 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+func (c *command) checks(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		return c.listChecks(args[1:])
+	case "get":
+		return c.getCheck(args[1:])
+	case "create":
+		return c.createCheck(args[1:])
+	case "update":
+		return c.updateCheck(args[1:])
+	case "delete":
+		return c.deleteCheck(args[1:])
+	case "pause":
+		return c.setActive(args[1:], false)
+	case "resume":
+		return c.setActive(args[1:], true)
+	case "run":
+		return c.runCheck(args[1:])
+	}
+	return errUsage
+}
+
+func (c *command) listChecks(args []string) error {
+	flags := c.flags("checks list")
+	active := flags.String("active", "", "only list active (true) or paused (false) checks")
+	interval := flags.Uint64("interval", 0, "only list checks with this interval")
+	search := flags.String("q", "", "only list checks whose name or URL contains this text")
+	tag := flags.String("tag", "", "only list checks with this tag")
+	group := flags.String("group", "", "only list checks in this group")
+	sort := flags.String("sort", "", "sort by name, url, interval or last_changed, prefixed by - for descending order")
+	limit := flags.Int("limit", 0, "maximum number of checks")
+	cursor := flags.String("cursor", "", "cursor of the page to list")
+	_, err := c.parse(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	set := func(name string, value string) {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	set("active", *active)
+	set("q", *search)
+	set("tag", *tag)
+	set("group", *group)
+	set("sort", *sort)
+	set("cursor", *cursor)
+	if *interval > 0 {
+		query.Set("interval", strconv.FormatUint(*interval, 10))
+	}
+	if *limit > 0 {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+
+	checks, next, err := c.client.GetChecks(c.ctx, query)
+	if err != nil {
+		return err
+	}
+
+	err = c.printChecks(checks)
+	if err == nil && next != "" && c.output == "table" {
+		fmt.Fprintf(c.stdout, "\nMore checks with -cursor %s\n", next)
+	}
+	return err
+}
+
+func (c *command) getCheck(args []string) error {
+	args, err := c.parse(c.flags("checks get"), args, 1)
+	if err != nil {
+		return err
+	}
+
+	check, err := c.client.GetCheck(c.ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return c.printCheck(&check)
+}
+
+// checkFlags defines the flags setting the fields of a check.
+type checkFlags struct {
+	key      *string
+	name     *string
+	url      *string
+	interval *uint64
+	email    *string
+	paused   *bool
+	channels stringList
+	tags     stringList
+	group    *string
+	headers  headerList
+	extract  *string
+	ignore   stringList
+	kind     *string
+	statuses intList
+	latency  *uint64
+	timeout  *uint64
+	retries  *int
+	backoff  *uint64
+}
+
+func newCheckFlags(flags *flag.FlagSet) *checkFlags {
+	f := &checkFlags{
+		key:      flags.String("key", "", "key identifying the check in configuration files"),
+		name:     flags.String("name", "", "name of the check"),
+		url:      flags.String("url", "", "URL to monitor"),
+		interval: flags.Uint64("interval", 60, "seconds between two runs"),
+		email:    flags.String("email", "", "address notified of changes"),
+		paused:   flags.Bool("paused", false, "don't run the check"),
+		group:    flags.String("group", "", "ID of the group of the check"),
+		headers:  make(headerList),
+		extract:  flags.String("extract", "", "regular expression selecting the monitored content"),
+		kind:     flags.String("kind", "", "content (the default) or availability"),
+		latency:  flags.Uint64("max-latency", 0, "milliseconds after which an availability check is down, 0 for no limit"),
+		timeout:  flags.Uint64("timeout", 0, "milliseconds a request fetching the page can take, 0 for the default"),
+		retries:  flags.Int("retries", 0, "times a request failing with a timeout or a 5xx status is retried"),
+		backoff:  flags.Uint64("retry-backoff", 0, "milliseconds before the first retry, 0 for the default"),
+	}
+	flags.Var(&f.channels, "channel", "ID of a channel notified of changes, can be repeated")
+	flags.Var(&f.tags, "tag", "tag of the check, can be repeated")
+	flags.Var(f.headers, "header", "header sent when fetching the page, as 'Name: value', can be repeated")
+	flags.Var(&f.ignore, "ignore", "regular expression removed from the content, can be repeated")
+	flags.Var(&f.statuses, "expected-status", "status code of an available page, can be repeated")
+	return f
+}
+
+func (c *command) createCheck(args []string) error {
+	flags := c.flags("checks create")
+	f := newCheckFlags(flags)
+	_, err := c.parse(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	check := models.Check{
+		Key:      *f.key,
+		Name:     *f.name,
+		URL:      *f.url,
+		Interval: *f.interval,
+		Email:    *f.email,
+		Active:   !*f.paused,
+		Channels: f.channels,
+		Tags:     f.tags,
+		Headers:  models.Headers(f.headers),
+		Extract:  *f.extract,
+		Ignore:   models.Patterns(f.ignore),
+
+		Kind:           *f.kind,
+		ExpectedStatus: models.StatusCodes(f.statuses),
+		MaxLatency:     *f.latency,
+
+		Timeout:      *f.timeout,
+		Retries:      *f.retries,
+		RetryBackoff: *f.backoff,
+	}
+	if *f.group != "" {
+		check.GroupID = f.group
+	}
+
+	created, err := c.client.CreateCheck(c.ctx, &check)
+	if err != nil {
+		return err
+	}
+	return c.printCheck(&created)
+}
+
+// updateCheck only changes the fields whose flag is set.
+func (c *command) updateCheck(args []string) error {
+	flags := c.flags("checks update")
+	f := newCheckFlags(flags)
+	args, err := c.parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var upd models.CheckUpdate
+	flags.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "key":
+			upd.Key = f.key
+		case "name":
+			upd.Name = f.name
+		case "url":
+			upd.URL = f.url
+		case "interval":
+			upd.Interval = f.interval
+		case "email":
+			upd.Email = f.email
+		case "paused":
+			active := !*f.paused
+			upd.Active = &active
+		case "channel":
+			channels := []string(f.channels)
+			upd.Channels = &channels
+		case "tag":
+			tags := []string(f.tags)
+			upd.Tags = &tags
+		case "group":
+			upd.GroupID = f.group
+		case "header":
+			headers := models.Headers(f.headers)
+			upd.Headers = &headers
+		case "extract":
+			upd.Extract = f.extract
+		case "ignore":
+			ignore := models.Patterns(f.ignore)
+			upd.Ignore = &ignore
+		case "kind":
+			upd.Kind = f.kind
+		case "expected-status":
+			statuses := models.StatusCodes(f.statuses)
+			upd.ExpectedStatus = &statuses
+		case "max-latency":
+			upd.MaxLatency = f.latency
+		case "timeout":
+			upd.Timeout = f.timeout
+		case "retries":
+			upd.Retries = f.retries
+		case "retry-backoff":
+			upd.RetryBackoff = f.backoff
+		}
+	})
+
+	check, err := c.client.UpdateCheck(c.ctx, args[0], &upd)
+	if err != nil {
+		return err
+	}
+	return c.printCheck(&check)
+}
+
+func (c *command) deleteCheck(args []string) error {
+	args, err := c.parse(c.flags("checks delete"), args, 1)
+	if err != nil {
+		return err
+	}
+	return c.client.DeleteCheck(c.ctx, args[0])
+}
+
+func (c *command) setActive(args []string, active bool) error {
+	name := "checks pause"
+	if active {
+		name = "checks resume"
+	}
+	args, err := c.parse(c.flags(name), args, 1)
+	if err != nil {
+		return err
+	}
+
+	check, err := c.client.UpdateCheck(c.ctx, args[0], &models.CheckUpdate{Active: &active})
+	if err != nil {
+		return err
+	}
+	return c.printCheck(&check)
+}
+
+func (c *command) runCheck(args []string) error {
+	flags := c.flags("checks run")
+	quiet := flags.Bool("quiet", false, "don't send notifications")
+	args, err := c.parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.client.RunCheck(c.ctx, args[0], !*quiet)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(result)
+	}
+
+	var rows [][]string
+	if result.Up != nil {
+		// Availability checks don't save statuses but probes.
+		rows = [][]string{
+			{"up", strconv.FormatBool(*result.Up)},
+			{"reason", orDash(result.Reason)},
+			{"transition", orDash(result.Transition)},
+			{"notified", strconv.FormatBool(result.Notified)},
+			{"status code", strconv.Itoa(result.StatusCode)},
+			{"duration", fmt.Sprintf("%dms", result.DurationMS)},
+			{"attempts", strconv.Itoa(result.Attempts)},
+			{"probe", orDash(result.ProbeID)},
+		}
+	} else {
+		rows = [][]string{
+			{"changed", strconv.FormatBool(result.Changed)},
+			{"baseline", strconv.FormatBool(result.Baseline)},
+			{"notified", strconv.FormatBool(result.Notified)},
+			{"status code", strconv.Itoa(result.StatusCode)},
+			{"duration", fmt.Sprintf("%dms", result.DurationMS)},
+			{"attempts", strconv.Itoa(result.Attempts)},
+			{"status", orDash(result.StatusID)},
+		}
+	}
+	err = c.printTable([]string{"FIELD", "VALUE"}, rows)
+	if err == nil && result.Diff != "" {
+		_, err = fmt.Fprint(c.stdout, "\n"+result.Diff)
+	}
+	return err
+}
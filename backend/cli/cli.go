@@ -0,0 +1,217 @@
+// Package cli implements the command-line client of the server, which talks
+// to it through the REST API.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/samirettali/webmonitor/client"
+	"gopkg.in/yaml.v3"
+)
+
+// Commands are the first arguments handled by Run.
+var Commands = []string{"checks", "history", "diff"}
+
+const usage = `Usage:
+  webmonitor checks list [flags]
+  webmonitor checks get|delete|pause|resume|run [flags] <id>
+  webmonitor checks create [flags]
+  webmonitor checks update [flags] <id>
+  webmonitor history [flags] <id>
+  webmonitor diff [flags] <id> [<status> <status>]
+
+The server and the API key are read from the file at $WEBMONITOR_CONFIG,
+defaulting to webmonitor/config.yaml in the user configuration directory,
+and can be overridden with $WEBMONITOR_URL, $WEBMONITOR_API_KEY and
+$WEBMONITOR_ORG. Every command accepts -o json to print JSON instead of
+tables.
+`
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// Config is the configuration of the client.
+type Config struct {
+	URL          string `yaml:"url"`
+	APIKey       string `yaml:"api_key"`
+	Organisation string `yaml:"organisation"`
+}
+
+// LoadConfig reads the configuration file, if any, and applies the
+// environment variables on top of it.
+func LoadConfig() (Config, error) {
+	config := Config{URL: "http://localhost:8000"}
+
+	path := os.Getenv("WEBMONITOR_CONFIG")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err == nil {
+			path = filepath.Join(dir, "webmonitor", "config.yaml")
+		}
+	}
+
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return Config{}, err
+		}
+		if err == nil {
+			err = yaml.Unmarshal(buf, &config)
+			if err != nil {
+				return Config{}, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+
+	if value, ok := os.LookupEnv("WEBMONITOR_URL"); ok {
+		config.URL = value
+	}
+	if value, ok := os.LookupEnv("WEBMONITOR_API_KEY"); ok {
+		config.APIKey = value
+	}
+	if value, ok := os.LookupEnv("WEBMONITOR_ORG"); ok {
+		config.Organisation = value
+	}
+	return config, nil
+}
+
+// command holds what every command needs.
+type command struct {
+	ctx    context.Context
+	client *client.Client
+	stdout io.Writer
+	// output is the format selected with -o.
+	output string
+}
+
+// Run runs the command in args, args[0] being one of Commands, and returns
+// the exit code.
+func Run(args []string, config Config, stdout io.Writer, stderr io.Writer) int {
+	cmd := &command{
+		ctx: context.Background(),
+		client: &client.Client{
+			BaseURL:      config.URL,
+			APIKey:       config.APIKey,
+			Organisation: config.Organisation,
+		},
+		stdout: stdout,
+	}
+
+	var err error
+	switch {
+	case len(args) == 0:
+		err = errUsage
+	case args[0] == "checks":
+		err = cmd.checks(args[1:])
+	case args[0] == "history":
+		err = cmd.history(args[1:])
+	case args[0] == "diff":
+		err = cmd.diff(args[1:])
+	default:
+		err = errUsage
+	}
+
+	if err == errUsage {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "webmonitor:", err)
+		return 1
+	}
+	return 0
+}
+
+// flags returns a flag set with the flags shared by every command.
+func (c *command) flags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&c.output, "o", "table", "output format, table or json")
+	return flags
+}
+
+// parse parses flags and returns the positional arguments, which unlike with
+// the flag package can be followed by more flags.
+func (c *command) parse(flags *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var rest []string
+	for {
+		err := flags.Parse(args)
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errUsage
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+
+	if c.output != "table" && c.output != "json" {
+		return nil, fmt.Errorf("unknown output format %s", c.output)
+	}
+	if positional >= 0 && len(rest) != positional {
+		return nil, errUsage
+	}
+	return rest, nil
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// intList is a number flag that can be repeated.
+type intList []int
+
+func (l *intList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *intList) Set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	*l = append(*l, n)
+	return nil
+}
+
+// headerList is a flag setting a header, written as "Name: value", that can
+// be repeated.
+type headerList map[string]string
+
+func (l headerList) String() string {
+	return fmt.Sprint(map[string]string(l))
+}
+
+func (l headerList) Set(raw string) error {
+	name, value, ok := strings.Cut(raw, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("%q is not a header, it must be written as 'Name: value'", raw)
+	}
+	l[name] = strings.TrimSpace(value)
+	return nil
+}
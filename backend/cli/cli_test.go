@@ -0,0 +1,370 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samirettali/webmonitor/apitypes"
+	"github.com/samirettali/webmonitor/models"
+)
+
+const (
+	testAPIKey = "secret"
+	testOrg    = "org"
+)
+
+// fakeServer serves the part of the API used by the client from memory and
+// records the requests it receives.
+type fakeServer struct {
+	mu       sync.Mutex
+	checks   map[string]models.Check
+	statuses []models.Status
+	requests []string
+}
+
+func newFakeServer(t *testing.T) (*httptest.Server, *fakeServer) {
+	date := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	f := &fakeServer{
+		checks: map[string]models.Check{
+			"c1": {
+				ID:       "c1",
+				Name:     "Homepage",
+				URL:      "https://example.com",
+				Interval: 60,
+				Email:    "ops@example.com",
+				Active:   true,
+				Tags:     []string{"web"},
+			},
+		},
+		// Newest first, as the history is listed.
+		statuses: []models.Status{
+			{ID: "s2", CheckID: "c1", Content: "hello\nworld\n", Date: date, Size: 12, Hash: "0123456789abcdef"},
+			{ID: "s1", CheckID: "c1", Content: "hello\n", Date: date.Add(-time.Hour), Size: 6, Hash: "fedcba9876543210"},
+		},
+	}
+
+	router := mux.NewRouter()
+	v1 := router.PathPrefix(apitypes.V1Prefix).Subrouter()
+	v1.Use(f.record)
+	v1.HandleFunc("/checks", f.getChecks).Methods(http.MethodGet)
+	v1.HandleFunc("/checks", f.createCheck).Methods(http.MethodPost)
+	v1.HandleFunc("/checks/{id}", f.getCheck).Methods(http.MethodGet)
+	v1.HandleFunc("/checks/{id}", f.updateCheck).Methods(http.MethodPatch)
+	v1.HandleFunc("/checks/{id}", f.deleteCheck).Methods(http.MethodDelete)
+	v1.HandleFunc("/checks/{id}/history", f.getHistory).Methods(http.MethodGet)
+	v1.HandleFunc("/checks/{id}/history/{status}", f.getStatus).Methods(http.MethodGet)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, f
+}
+
+// record rejects unauthenticated requests and remembers the others.
+func (f *fakeServer) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testAPIKey || r.Header.Get(apitypes.OrgHeader) != testOrg {
+			f.problem(w, r, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, apitypes.V1Prefix))
+		f.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *fakeServer) problem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	w.Header().Set("Content-Type", apitypes.ProblemContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&apitypes.Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+}
+
+func (f *fakeServer) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeServer) getChecks(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tag := r.URL.Query().Get("tag")
+	checks := make([]models.Check, 0, len(f.checks))
+	for _, check := range f.checks {
+		if tag == "" || (len(check.Tags) > 0 && check.Tags[0] == tag) {
+			checks = append(checks, check)
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].ID < checks[j].ID })
+	if r.URL.Query().Get("limit") != "" {
+		w.Header().Set(apitypes.NextCursorHeader, "next")
+	}
+	f.reply(w, http.StatusOK, checks)
+}
+
+func (f *fakeServer) createCheck(w http.ResponseWriter, r *http.Request) {
+	var check models.Check
+	err := json.NewDecoder(r.Body).Decode(&check)
+	if err != nil || check.Name == "" {
+		f.problem(w, r, http.StatusBadRequest, "Invalid check")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	check.ID = "c2"
+	f.checks[check.ID] = check
+	f.reply(w, http.StatusCreated, check)
+}
+
+func (f *fakeServer) getCheck(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	check, ok := f.checks[mux.Vars(r)["id"]]
+	if !ok {
+		f.problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
+	f.reply(w, http.StatusOK, check)
+}
+
+func (f *fakeServer) updateCheck(w http.ResponseWriter, r *http.Request) {
+	var upd models.CheckUpdate
+	err := json.NewDecoder(r.Body).Decode(&upd)
+	if err != nil {
+		f.problem(w, r, http.StatusBadRequest, "Invalid update")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	check, ok := f.checks[mux.Vars(r)["id"]]
+	if !ok {
+		f.problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
+	if upd.Name != nil {
+		check.Name = *upd.Name
+	}
+	if upd.Interval != nil {
+		check.Interval = *upd.Interval
+	}
+	if upd.Active != nil {
+		check.Active = *upd.Active
+	}
+	if upd.Headers != nil {
+		check.Headers = *upd.Headers
+	}
+	f.checks[check.ID] = check
+	f.reply(w, http.StatusOK, check)
+}
+
+func (f *fakeServer) deleteCheck(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := mux.Vars(r)["id"]
+	if _, ok := f.checks[id]; !ok {
+		f.problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
+	delete(f.checks, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeServer) getHistory(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.checks[mux.Vars(r)["id"]]; !ok {
+		f.problem(w, r, http.StatusNotFound, "The check does not exist")
+		return
+	}
+	// Contents are only listed when asked for.
+	statuses := make([]models.Status, len(f.statuses))
+	for i, status := range f.statuses {
+		status.Content = ""
+		statuses[i] = status
+	}
+	f.reply(w, http.StatusOK, statuses)
+}
+
+func (f *fakeServer) getStatus(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, status := range f.statuses {
+		if status.CheckID == mux.Vars(r)["id"] && status.ID == mux.Vars(r)["status"] {
+			f.reply(w, http.StatusOK, status)
+			return
+		}
+	}
+	f.problem(w, r, http.StatusNotFound, "The status does not exist")
+}
+
+// run runs the command line against srv and returns its exit code and
+// outputs.
+func run(srv *httptest.Server, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	config := Config{URL: srv.URL, APIKey: testAPIKey, Organisation: testOrg}
+	code := Run(args, config, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// requests are the requests the command sends.
+		requests []string
+		// table and json are strings found in the output in each format.
+		table []string
+		json  []string
+	}{
+		{
+			name:     "list",
+			args:     []string{"checks", "list", "-tag", "web", "-limit", "1"},
+			requests: []string{"GET /checks"},
+			table:    []string{"ID", "LAST CHANGED", "c1", "Homepage", "https://example.com", "More checks with -cursor next"},
+			json:     []string{`"id": "c1"`, `"name": "Homepage"`},
+		},
+		{
+			name:     "get",
+			args:     []string{"checks", "get", "c1"},
+			requests: []string{"GET /checks/c1"},
+			table:    []string{"FIELD", "name", "Homepage", "interval", "60", "tags", "web"},
+			json:     []string{`"id": "c1"`, `"interval": 60`},
+		},
+		{
+			name:     "create",
+			args:     []string{"checks", "create", "-name", "Docs", "-url", "https://example.com/docs", "-email", "ops@example.com", "-tag", "docs", "-header", "Accept: text/html"},
+			requests: []string{"POST /checks"},
+			table:    []string{"c2", "Docs", "https://example.com/docs", "Accept: text/html"},
+			json:     []string{`"id": "c2"`, `"name": "Docs"`, `"Accept": "text/html"`},
+		},
+		{
+			name:     "update",
+			args:     []string{"checks", "update", "c1", "-interval", "300"},
+			requests: []string{"PATCH /checks/c1"},
+			table:    []string{"interval", "300"},
+			json:     []string{`"interval": 300`},
+		},
+		{
+			name:     "delete",
+			args:     []string{"checks", "delete", "c1"},
+			requests: []string{"DELETE /checks/c1"},
+		},
+		{
+			name:     "pause",
+			args:     []string{"checks", "pause", "c1"},
+			requests: []string{"PATCH /checks/c1"},
+			table:    []string{"active", "false"},
+			json:     []string{`"active": false`},
+		},
+		{
+			name:     "resume",
+			args:     []string{"checks", "resume", "c1"},
+			requests: []string{"PATCH /checks/c1"},
+			table:    []string{"active", "true"},
+			json:     []string{`"active": true`},
+		},
+		{
+			name:     "history",
+			args:     []string{"history", "c1"},
+			requests: []string{"GET /checks/c1/history"},
+			table:    []string{"ID", "DATE", "SIZE", "HASH", "s2", "0123456789ab", "s1"},
+			json:     []string{`"id": "s2"`, `"id": "s1"`},
+		},
+		{
+			name:     "diff",
+			args:     []string{"diff", "c1"},
+			requests: []string{"GET /checks/c1/history", "GET /checks/c1/history/s1", "GET /checks/c1/history/s2"},
+			table:    []string{"--- s1", "+++ s2", "+world"},
+			json:     []string{`"from": "s1"`, `"to": "s2"`, `+world`},
+		},
+		{
+			name:     "diff of given statuses",
+			args:     []string{"diff", "c1", "s2", "s1"},
+			requests: []string{"GET /checks/c1/history/s2", "GET /checks/c1/history/s1"},
+			table:    []string{"--- s2", "+++ s1", "-world"},
+			json:     []string{`"from": "s2"`, `"to": "s1"`},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, output := range []string{"table", "json"} {
+			t.Run(tt.name+"/"+output, func(t *testing.T) {
+				srv, f := newFakeServer(t)
+				args := append(append([]string{}, tt.args...), "-o", output)
+
+				code, stdout, stderr := run(srv, args...)
+				if code != 0 {
+					t.Fatalf("exit code %d: %s", code, stderr)
+				}
+
+				f.mu.Lock()
+				requests := f.requests
+				f.mu.Unlock()
+				if strings.Join(requests, ", ") != strings.Join(tt.requests, ", ") {
+					t.Errorf("requests = %v, want %v", requests, tt.requests)
+				}
+
+				want := tt.table
+				if output == "json" {
+					want = tt.json
+					if len(tt.json) > 0 && !json.Valid([]byte(stdout)) {
+						t.Fatalf("output is not JSON:\n%s", stdout)
+					}
+				}
+				for _, s := range want {
+					if !strings.Contains(stdout, s) {
+						t.Errorf("output doesn't contain %q:\n%s", s, stdout)
+					}
+				}
+				if len(want) == 0 && stdout != "" {
+					t.Errorf("unexpected output:\n%s", stdout)
+				}
+			})
+		}
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	srv, _ := newFakeServer(t)
+
+	code, _, stderr := run(srv, "checks", "get", "missing")
+	if code != 1 || !strings.Contains(stderr, "server returned 404: The check does not exist") {
+		t.Errorf("get of a missing check: exit code %d, %q", code, stderr)
+	}
+
+	code, _, stderr = run(srv, "checks", "get")
+	if code != 2 || !strings.Contains(stderr, "Usage:") {
+		t.Errorf("get without ID: exit code %d, %q", code, stderr)
+	}
+
+	code, _, stderr = run(srv, "checks", "create", "-header", "nocolon")
+	if code != 2 {
+		t.Errorf("create with an invalid header: exit code %d, %q", code, stderr)
+	}
+
+	code, _, stderr = run(srv, "checks", "list", "-o", "xml")
+	if code != 1 || !strings.Contains(stderr, "unknown output format xml") {
+		t.Errorf("list as XML: exit code %d, %q", code, stderr)
+	}
+}
@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/samirettali/webmonitor/models"
+)
+
+func (c *command) history(args []string) error {
+	flags := c.flags("history")
+	limit := flags.Int("limit", 20, "maximum number of statuses")
+	from := flags.String("from", "", "only list statuses saved from this RFC 3339 time")
+	to := flags.String("to", "", "only list statuses saved before this RFC 3339 time")
+	asc := flags.Bool("asc", false, "list the oldest statuses first")
+	cursor := flags.String("cursor", "", "cursor of the page to list")
+	args, err := c.parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("fields", "id,date,size,hash")
+	query.Set("limit", strconv.Itoa(*limit))
+	if *from != "" {
+		query.Set("from", *from)
+	}
+	if *to != "" {
+		query.Set("to", *to)
+	}
+	if *asc {
+		query.Set("order", "asc")
+	}
+	if *cursor != "" {
+		query.Set("cursor", *cursor)
+	}
+
+	statuses, next, err := c.client.GetHistory(c.ctx, args[0], query)
+	if err != nil {
+		return err
+	}
+
+	err = c.printHistory(statuses)
+	if err == nil && next != "" && c.output == "table" {
+		fmt.Fprintf(c.stdout, "\nMore statuses with -cursor %s\n", next)
+	}
+	return err
+}
+
+// diff prints the changes between two statuses of a check as a unified
+// diff, comparing the latest two when they are not given.
+func (c *command) diff(args []string) error {
+	flags := c.flags("diff")
+	context := flags.Int("context", 3, "lines of context around changes")
+	args, err := c.parse(flags, args, -1)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 && len(args) != 3 {
+		return errUsage
+	}
+
+	ids := args[1:]
+	if len(ids) == 0 {
+		query := url.Values{"fields": {"id"}, "limit": {"2"}}
+		latest, _, err := c.client.GetHistory(c.ctx, args[0], query)
+		if err != nil {
+			return err
+		}
+		if len(latest) < 2 {
+			return fmt.Errorf("check %s has no changes to compare", args[0])
+		}
+		ids = []string{latest[1].ID, latest[0].ID}
+	}
+
+	statuses := make([]models.Status, 2)
+	for i, id := range ids {
+		statuses[i], err = c.client.GetHistoryStatus(c.ctx, args[0], id)
+		if err != nil {
+			return err
+		}
+	}
+
+	label := func(s *models.Status) string {
+		return fmt.Sprintf("%s %s", s.ID, s.Date.Local().Format(time.RFC3339))
+	}
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(statuses[0].Content),
+		B:        difflib.SplitLines(statuses[1].Content),
+		FromFile: label(&statuses[0]),
+		ToFile:   label(&statuses[1]),
+		Context:  *context,
+	}
+
+	if c.output == "json" {
+		text, err := difflib.GetUnifiedDiffString(diff)
+		if err != nil {
+			return err
+		}
+		return c.printJSON(map[string]string{
+			"from": statuses[0].ID,
+			"to":   statuses[1].ID,
+			"diff": text,
+		})
+	}
+	return difflib.WriteUnifiedDiff(c.stdout, diff)
+}
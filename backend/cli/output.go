@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+func (c *command) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable prints rows as aligned columns under a header.
+func (c *command) printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// orDash returns value, or a dash when it is empty.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
+
+func (c *command) printChecks(checks []models.Check) error {
+	if c.output == "json" {
+		return c.printJSON(checks)
+	}
+
+	rows := make([][]string, len(checks))
+	for i, check := range checks {
+		rows[i] = []string{
+			check.ID,
+			check.Name,
+			check.URL,
+			strconv.FormatUint(check.Interval, 10),
+			strconv.FormatBool(check.Active),
+			formatList(check.Tags),
+			formatTime(check.LastChanged),
+		}
+	}
+	return c.printTable([]string{"ID", "NAME", "URL", "INTERVAL", "ACTIVE", "TAGS", "LAST CHANGED"}, rows)
+}
+
+// printCheck prints a check as a list of its fields.
+func (c *command) printCheck(check *models.Check) error {
+	if c.output == "json" {
+		return c.printJSON(check)
+	}
+
+	group := "-"
+	if check.GroupID != nil {
+		group = *check.GroupID
+	}
+	statuses := make([]string, len(check.ExpectedStatus))
+	for i, code := range check.ExpectedStatus {
+		statuses[i] = strconv.Itoa(code)
+	}
+	millis := func(ms uint64) string {
+		if ms == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%dms", ms)
+	}
+	headers := make([]string, 0, len(check.Headers))
+	for name, value := range check.Headers {
+		headers = append(headers, name+": "+value)
+	}
+	sort.Strings(headers)
+
+	return c.printTable([]string{"FIELD", "VALUE"}, [][]string{
+		{"id", check.ID},
+		{"key", orDash(check.Key)},
+		{"name", check.Name},
+		{"url", check.URL},
+		{"interval", strconv.FormatUint(check.Interval, 10)},
+		{"email", check.Email},
+		{"active", strconv.FormatBool(check.Active)},
+		{"channels", formatList(check.Channels)},
+		{"tags", formatList(check.Tags)},
+		{"group", group},
+		{"headers", formatList(headers)},
+		{"extract", orDash(check.Extract)},
+		{"ignore", formatList(check.Ignore)},
+		{"kind", orDash(check.Kind)},
+		{"expected status", formatList(statuses)},
+		{"max latency", millis(check.MaxLatency)},
+		{"timeout", millis(check.Timeout)},
+		{"retries", strconv.Itoa(check.Retries)},
+		{"retry backoff", millis(check.RetryBackoff)},
+		{"last changed", formatTime(check.LastChanged)},
+	})
+}
+
+func (c *command) printHistory(statuses []models.Status) error {
+	if c.output == "json" {
+		return c.printJSON(statuses)
+	}
+
+	rows := make([][]string, len(statuses))
+	for i, status := range statuses {
+		hash := status.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		rows[i] = []string{
+			status.ID,
+			formatTime(&status.Date),
+			strconv.FormatInt(status.Size, 10),
+			hash,
+		}
+	}
+	return c.printTable([]string{"ID", "DATE", "SIZE", "HASH"}, rows)
+}
@@ -0,0 +1,201 @@
+// Package client is a client for the REST API of the server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/samirettali/webmonitor/apitypes"
+	"github.com/samirettali/webmonitor/models"
+)
+
+// Client sends authenticated requests to version 1 of the API of a server,
+// whose root is BaseURL. Organisation selects the organisation requests
+// operate on, the server picks one when empty.
+type Client struct {
+	BaseURL      string
+	APIKey       string
+	Organisation string
+	HTTP         *http.Client
+}
+
+// Error is returned when the server answers with an error status. Fields
+// are the invalid fields of the request, if the server reported them.
+type Error struct {
+	Status  int
+	Message string
+	Fields  []apitypes.FieldError
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("server returned %d %s", e.Status, http.StatusText(e.Status))
+	if e.Message != "" {
+		msg = fmt.Sprintf("server returned %d: %s", e.Status, e.Message)
+	}
+	for _, field := range e.Fields {
+		msg += fmt.Sprintf("\n  %s: %s", field.Field, field.Rule)
+		if field.Param != "" {
+			msg += " " + field.Param
+		}
+	}
+	return msg
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response
+// into out, if not nil. It returns the headers of the response.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) (http.Header, error) {
+	u := strings.TrimRight(c.BaseURL, "/") + apitypes.V1Prefix + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	if c.Organisation != "" {
+		req.Header.Set(apitypes.OrgHeader, c.Organisation)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{Status: resp.StatusCode}
+		// Errors are either problem details or, for older servers, an
+		// object with an error message.
+		var msg struct {
+			apitypes.Problem
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&msg) == nil {
+			apiErr.Message = msg.Detail
+			if apiErr.Message == "" {
+				apiErr.Message = msg.Error
+			}
+			apiErr.Fields = msg.Errors
+		}
+		return nil, apiErr
+	}
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp.Header, nil
+}
+
+func checkPath(id string) string {
+	return "/checks/" + url.PathEscape(id)
+}
+
+// GetChecks lists checks with the filters of the /checks endpoint and
+// returns the cursor of the next page, if any.
+func (c *Client) GetChecks(ctx context.Context, query url.Values) ([]models.Check, string, error) {
+	var checks []models.Check
+	header, err := c.do(ctx, http.MethodGet, "/checks", query, nil, &checks)
+	if err != nil {
+		return nil, "", err
+	}
+	return checks, header.Get(apitypes.NextCursorHeader), nil
+}
+
+func (c *Client) GetCheck(ctx context.Context, id string) (models.Check, error) {
+	var check models.Check
+	_, err := c.do(ctx, http.MethodGet, checkPath(id), nil, nil, &check)
+	return check, err
+}
+
+func (c *Client) CreateCheck(ctx context.Context, check *models.Check) (models.Check, error) {
+	var created models.Check
+	_, err := c.do(ctx, http.MethodPost, "/checks", nil, check, &created)
+	return created, err
+}
+
+func (c *Client) UpdateCheck(ctx context.Context, id string, upd *models.CheckUpdate) (models.Check, error) {
+	var check models.Check
+	_, err := c.do(ctx, http.MethodPatch, checkPath(id), nil, upd, &check)
+	return check, err
+}
+
+func (c *Client) DeleteCheck(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, checkPath(id), nil, nil, nil)
+	return err
+}
+
+// RunResult is the outcome of running a check.
+type RunResult struct {
+	Changed    bool  `json:"changed"`
+	Baseline   bool  `json:"baseline"`
+	Notified   bool  `json:"notified"`
+	StatusCode int   `json:"status_code"`
+	DurationMS int64 `json:"duration_ms"`
+	// Attempts is the number of requests made to fetch the page.
+	Attempts int    `json:"attempts"`
+	StatusID string `json:"status_id"`
+	Diff     string `json:"diff"`
+	// Up, ProbeID, Reason and Transition are set by availability checks.
+	Up         *bool  `json:"up"`
+	ProbeID    string `json:"probe_id"`
+	Reason     string `json:"reason"`
+	Transition string `json:"transition"`
+}
+
+// RunCheck runs a check immediately, notifying about changes if notify is
+// true.
+func (c *Client) RunCheck(ctx context.Context, id string, notify bool) (RunResult, error) {
+	var result RunResult
+	query := url.Values{"notify": {strconv.FormatBool(notify)}}
+	_, err := c.do(ctx, http.MethodPost, checkPath(id)+"/run", query, nil, &result)
+	return result, err
+}
+
+// GetHistory lists the statuses of a check with the parameters of the
+// history endpoint and returns the cursor of the next page, if any.
+func (c *Client) GetHistory(ctx context.Context, id string, query url.Values) ([]models.Status, string, error) {
+	var statuses []models.Status
+	header, err := c.do(ctx, http.MethodGet, checkPath(id)+"/history", query, nil, &statuses)
+	if err != nil {
+		return nil, "", err
+	}
+	return statuses, header.Get(apitypes.NextCursorHeader), nil
+}
+
+// GetHistoryStatus returns a status of a check, including its content.
+func (c *Client) GetHistoryStatus(ctx context.Context, id string, statusID string) (models.Status, error) {
+	var status models.Status
+	_, err := c.do(ctx, http.MethodGet, checkPath(id)+"/history/"+url.PathEscape(statusID), nil, nil, &status)
+	return status, err
+}
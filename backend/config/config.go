@@ -0,0 +1,194 @@
+// Package config reads declarative descriptions of the checks, channels and
+// tags of an organisation and reconciles the storage with them.
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/samirettali/webmonitor/extract"
+	"github.com/samirettali/webmonitor/models"
+	"gopkg.in/yaml.v3"
+)
+
+// File is a configuration file. Checks and channels are identified by their
+// key, which is stored with them, so that they can be renamed and changed
+// without losing their history.
+type File struct {
+	// Organisation is the ID of the organisation the file describes.
+	Organisation string    `yaml:"organisation"`
+	Channels     []Channel `yaml:"channels"`
+	// Tags are created even if no check uses them.
+	Tags   []string `yaml:"tags"`
+	Checks []Check  `yaml:"checks"`
+}
+
+type Channel struct {
+	Key    string `yaml:"key"`
+	Name   string `yaml:"name"`
+	Type   string `yaml:"type"`
+	Target string `yaml:"target"`
+}
+
+type Check struct {
+	Key      string `yaml:"key"`
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Interval uint64 `yaml:"interval"`
+	Email    string `yaml:"email"`
+	// Active defaults to true.
+	Active *bool `yaml:"active"`
+	// Channels are the keys of the channels notified of changes.
+	Channels []string `yaml:"channels"`
+	Tags     []string `yaml:"tags"`
+	// Headers are sent with the requests fetching the page.
+	Headers map[string]string `yaml:"headers"`
+	// Extract and Ignore are the regular expressions selecting the
+	// monitored content of the page, as in the API.
+	Extract string   `yaml:"extract"`
+	Ignore  []string `yaml:"ignore"`
+	// Kind is content, the default, or availability. ExpectedStatus and
+	// MaxLatency are the conditions of availability checks, as in the API.
+	Kind           string `yaml:"kind"`
+	ExpectedStatus []int  `yaml:"expected_status"`
+	MaxLatency     uint64 `yaml:"max_latency"`
+	// Timeout, Retries and RetryBackoff say how the page is fetched, as in
+	// the API.
+	Timeout      uint64 `yaml:"timeout"`
+	Retries      int    `yaml:"retries"`
+	RetryBackoff uint64 `yaml:"retry_backoff"`
+}
+
+// Load reads and validates a configuration file.
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file File
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	err = dec.Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	err = file.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &file, nil
+}
+
+// Validate checks that keys are unique, that checks only refer to channels
+// of the file and that every entity is valid as if created through the API.
+func (f *File) Validate() error {
+	v := validator.New()
+
+	channels := make(map[string]bool, len(f.Channels))
+	for i, channel := range f.Channels {
+		if channel.Key == "" {
+			return fmt.Errorf("channel %d has no key", i+1)
+		}
+		if channels[channel.Key] {
+			return fmt.Errorf("channel %s is defined twice", channel.Key)
+		}
+		channels[channel.Key] = true
+
+		err := v.Struct(channel.model())
+		if err != nil {
+			return fmt.Errorf("channel %s: %v", channel.Key, err)
+		}
+	}
+
+	for _, tag := range f.Tags {
+		err := v.Var(tag, "min=1,max=50")
+		if err != nil {
+			return fmt.Errorf("tag %q: %v", tag, err)
+		}
+	}
+
+	checks := make(map[string]bool, len(f.Checks))
+	for i, check := range f.Checks {
+		if check.Key == "" {
+			return fmt.Errorf("check %d has no key", i+1)
+		}
+		if checks[check.Key] {
+			return fmt.Errorf("check %s is defined twice", check.Key)
+		}
+		checks[check.Key] = true
+
+		for _, key := range check.Channels {
+			if !channels[key] {
+				return fmt.Errorf("check %s: unknown channel %s", check.Key, key)
+			}
+		}
+
+		model := check.model(nil)
+		err := v.Struct(model)
+		if err != nil {
+			return fmt.Errorf("check %s: %v", check.Key, err)
+		}
+		_, err = extract.Compile(&model)
+		if err != nil {
+			return fmt.Errorf("check %s: %v", check.Key, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Channel) model() models.Channel {
+	return models.Channel{
+		Key:    c.Key,
+		Name:   c.Name,
+		Type:   c.Type,
+		Target: c.Target,
+	}
+}
+
+// model returns the check described by c. channelIDs maps channel keys to
+// IDs; channels missing from it are left out.
+func (c *Check) model(channelIDs map[string]string) models.Check {
+	active := true
+	if c.Active != nil {
+		active = *c.Active
+	}
+
+	kind := c.Kind
+	if kind == "" {
+		kind = models.KindContent
+	}
+
+	channels := make([]string, 0, len(c.Channels))
+	for _, key := range c.Channels {
+		if id, ok := channelIDs[key]; ok {
+			channels = append(channels, id)
+		}
+	}
+
+	return models.Check{
+		Key:      c.Key,
+		Name:     c.Name,
+		URL:      c.URL,
+		Interval: c.Interval,
+		Email:    c.Email,
+		Active:   active,
+		Channels: channels,
+		Tags:     unique(c.Tags),
+		Headers:  c.Headers,
+		Extract:  c.Extract,
+		Ignore:   c.Ignore,
+
+		Kind:           kind,
+		ExpectedStatus: c.ExpectedStatus,
+		MaxLatency:     c.MaxLatency,
+
+		Timeout:      c.Timeout,
+		Retries:      c.Retries,
+		RetryBackoff: c.RetryBackoff,
+	}
+}
@@ -0,0 +1,489 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samirettali/webmonitor/logger"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/storage"
+)
+
+// Actor is the name changes made by a plan are recorded under in the audit
+// log.
+const Actor = "webmonitor apply"
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+var actionSymbols = map[string]string{
+	ActionCreate: "+",
+	ActionUpdate: "~",
+	ActionDelete: "-",
+}
+
+// Change is a single step of a plan. Key is the key of checks and channels
+// and the name of tags, Fields the fields modified by an update.
+type Change struct {
+	Action string
+	Kind   string
+	Key    string
+	Fields []string
+
+	apply func(ctx context.Context) error
+}
+
+func (c *Change) String() string {
+	s := fmt.Sprintf("%s %s %s", actionSymbols[c.Action], c.Kind, c.Key)
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+	return s
+}
+
+// Plan holds the changes that make an organisation match a file.
+type Plan struct {
+	OrgID   string
+	Changes []Change
+
+	store  storage.Storage
+	logger logger.Logger
+}
+
+// NewPlan compares the file with the organisation in the storage. Checks and
+// channels are created or updated to match the file. With prune, the ones
+// that have a key but are missing from the file are deleted, as well as the
+// tags that are neither in the file nor used by a remaining check. Checks
+// and channels without a key, such as the ones created from the dashboard,
+// are never modified.
+func NewPlan(ctx context.Context, store storage.Storage, log logger.Logger, orgID string, file *File, prune bool) (*Plan, error) {
+	_, err := store.GetOrganisation(ctx, orgID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown organisation %s", orgID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{OrgID: orgID, store: store, logger: log}
+
+	channelIDs, channelDeletes, err := p.planChannels(ctx, file, prune)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := store.GetChecks(ctx, models.CheckFilter{OrgID: orgID})
+	if err != nil {
+		return nil, err
+	}
+
+	tagDeletes, err := p.planTags(ctx, file, existing, prune)
+	if err != nil {
+		return nil, err
+	}
+
+	checkDeletes := p.planChecks(file, existing, channelIDs, prune)
+
+	// Channels and tags are deleted once no check refers to them anymore.
+	p.Changes = append(p.Changes, checkDeletes...)
+	p.Changes = append(p.Changes, channelDeletes...)
+	p.Changes = append(p.Changes, tagDeletes...)
+
+	return p, nil
+}
+
+// Apply performs the changes in order, stopping at the first failure.
+func (p *Plan) Apply(ctx context.Context) error {
+	for i := range p.Changes {
+		change := &p.Changes[i]
+		err := change.apply(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %v", change, err)
+		}
+	}
+	return nil
+}
+
+// planChannels adds the creations and updates of channels and returns the
+// IDs of the channels of the file by key, along with the deletions, which
+// must happen after the checks have been updated.
+func (p *Plan) planChannels(ctx context.Context, file *File, prune bool) (map[string]string, []Change, error) {
+	existing, err := p.store.GetChannels(ctx, p.OrgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	byKey := make(map[string]*models.Channel, len(existing))
+	for i := range existing {
+		if existing[i].Key != "" {
+			byKey[existing[i].Key] = &existing[i]
+		}
+	}
+
+	ids := make(map[string]string, len(file.Channels))
+	for i := range file.Channels {
+		desired := file.Channels[i].model()
+		desired.OrgID = p.OrgID
+
+		current, ok := byKey[desired.Key]
+		if !ok {
+			desired.ID = uuid.NewString()
+			ids[desired.Key] = desired.ID
+			p.Changes = append(p.Changes, Change{
+				Action: ActionCreate,
+				Kind:   "channel",
+				Key:    desired.Key,
+				apply: func(ctx context.Context) error {
+					err := p.store.CreateChannel(ctx, &desired)
+					if err == nil {
+						p.audit(ctx, "create", "channel", desired.ID, nil, &desired)
+					}
+					return err
+				},
+			})
+			continue
+		}
+
+		ids[desired.Key] = current.ID
+		if current.Type != desired.Type {
+			return nil, nil, fmt.Errorf("channel %s: the type can't be changed from %s to %s", desired.Key, current.Type, desired.Type)
+		}
+
+		var fields []string
+		if current.Name != desired.Name {
+			fields = append(fields, "name")
+		}
+		if current.Target != desired.Target {
+			fields = append(fields, "target")
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		p.Changes = append(p.Changes, Change{
+			Action: ActionUpdate,
+			Kind:   "channel",
+			Key:    desired.Key,
+			Fields: fields,
+			apply: func(ctx context.Context) error {
+				after, err := p.store.UpdateChannel(ctx, p.OrgID, current.ID, &models.ChannelUpdate{
+					Name:   &desired.Name,
+					Target: &desired.Target,
+				})
+				if err == nil {
+					p.audit(ctx, "update", "channel", current.ID, current, &after)
+				}
+				return err
+			},
+		})
+	}
+
+	var deletes []Change
+	if prune {
+		for i := range existing {
+			current := &existing[i]
+			if _, ok := ids[current.Key]; current.Key == "" || ok {
+				continue
+			}
+			deletes = append(deletes, Change{
+				Action: ActionDelete,
+				Kind:   "channel",
+				Key:    current.Key,
+				apply: func(ctx context.Context) error {
+					err := p.store.DeleteChannel(ctx, p.OrgID, current.ID)
+					if err == nil {
+						p.audit(ctx, "delete", "channel", current.ID, current, nil)
+					}
+					return err
+				},
+			})
+		}
+	}
+
+	return ids, deletes, nil
+}
+
+// planTags adds the creation of the tags of the file that don't exist yet
+// and, with prune, returns the deletion of the ones nobody uses anymore.
+func (p *Plan) planTags(ctx context.Context, file *File, checks []models.Check, prune bool) ([]Change, error) {
+	existing, err := p.store.GetTags(ctx, p.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, tag := range existing {
+		exists[tag.Name] = true
+	}
+
+	wanted := append([]string{}, file.Tags...)
+	for _, check := range file.Checks {
+		wanted = append(wanted, check.Tags...)
+	}
+	wanted = unique(wanted)
+
+	for _, name := range wanted {
+		if exists[name] {
+			continue
+		}
+		tag := models.Tag{ID: uuid.NewString(), OrgID: p.OrgID, Name: name}
+		p.Changes = append(p.Changes, Change{
+			Action: ActionCreate,
+			Kind:   "tag",
+			Key:    name,
+			apply: func(ctx context.Context) error {
+				return p.store.CreateTag(ctx, &tag)
+			},
+		})
+	}
+
+	if !prune {
+		return nil, nil
+	}
+
+	// Checks that are not described by the file keep their tags, unless
+	// they have a key and are therefore about to be deleted.
+	used := make(map[string]bool)
+	for _, name := range wanted {
+		used[name] = true
+	}
+	for _, check := range checks {
+		if check.Key != "" {
+			continue
+		}
+		for _, name := range check.Tags {
+			used[name] = true
+		}
+	}
+
+	var deletes []Change
+	for i := range existing {
+		tag := &existing[i]
+		if used[tag.Name] {
+			continue
+		}
+		deletes = append(deletes, Change{
+			Action: ActionDelete,
+			Kind:   "tag",
+			Key:    tag.Name,
+			apply: func(ctx context.Context) error {
+				return p.store.DeleteTag(ctx, p.OrgID, tag.ID)
+			},
+		})
+	}
+	return deletes, nil
+}
+
+// planChecks adds the creations and updates of checks and returns the
+// deletions.
+func (p *Plan) planChecks(file *File, existing []models.Check, channelIDs map[string]string, prune bool) []Change {
+	byKey := make(map[string]*models.Check, len(existing))
+	for i := range existing {
+		if existing[i].Key != "" {
+			byKey[existing[i].Key] = &existing[i]
+		}
+	}
+
+	described := make(map[string]bool, len(file.Checks))
+	for i := range file.Checks {
+		desired := file.Checks[i].model(channelIDs)
+		desired.OrgID = p.OrgID
+		described[desired.Key] = true
+
+		current, ok := byKey[desired.Key]
+		if !ok {
+			desired.ID = uuid.NewString()
+			p.Changes = append(p.Changes, Change{
+				Action: ActionCreate,
+				Kind:   "check",
+				Key:    desired.Key,
+				apply: func(ctx context.Context) error {
+					err := p.store.CreateCheck(ctx, &desired)
+					if err == nil {
+						p.audit(ctx, "create", "check", desired.ID, nil, &desired)
+					}
+					return err
+				},
+			})
+			continue
+		}
+
+		upd, fields := checkUpdate(current, &desired)
+		if len(fields) == 0 {
+			continue
+		}
+
+		p.Changes = append(p.Changes, Change{
+			Action: ActionUpdate,
+			Kind:   "check",
+			Key:    desired.Key,
+			Fields: fields,
+			apply: func(ctx context.Context) error {
+				after, err := p.store.UpdateCheck(ctx, p.OrgID, current.ID, upd)
+				if err == nil {
+					action := "update"
+					if current.Active != after.Active {
+						action = map[bool]string{true: "resume", false: "pause"}[after.Active]
+					}
+					p.audit(ctx, action, "check", current.ID, current, &after)
+				}
+				return err
+			},
+		})
+	}
+
+	var deletes []Change
+	if prune {
+		for i := range existing {
+			current := &existing[i]
+			if current.Key == "" || described[current.Key] {
+				continue
+			}
+			deletes = append(deletes, Change{
+				Action: ActionDelete,
+				Kind:   "check",
+				Key:    current.Key,
+				apply: func(ctx context.Context) error {
+					err := p.store.DeleteCheck(ctx, p.OrgID, current.ID)
+					if err == nil {
+						p.audit(ctx, "delete", "check", current.ID, current, nil)
+					}
+					return err
+				},
+			})
+		}
+	}
+	return deletes
+}
+
+// checkUpdate returns the update turning current into desired and the names
+// of the fields it changes.
+func checkUpdate(current *models.Check, desired *models.Check) (*models.CheckUpdate, []string) {
+	var upd models.CheckUpdate
+	var fields []string
+
+	if current.Name != desired.Name {
+		upd.Name = &desired.Name
+		fields = append(fields, "name")
+	}
+	if current.URL != desired.URL {
+		upd.URL = &desired.URL
+		fields = append(fields, "url")
+	}
+	if current.Interval != desired.Interval {
+		upd.Interval = &desired.Interval
+		fields = append(fields, "interval")
+	}
+	if current.Email != desired.Email {
+		upd.Email = &desired.Email
+		fields = append(fields, "email")
+	}
+	if current.Active != desired.Active {
+		upd.Active = &desired.Active
+		fields = append(fields, "active")
+	}
+	if !reflect.DeepEqual(unique(current.Channels), unique(desired.Channels)) {
+		upd.Channels = &desired.Channels
+		fields = append(fields, "channels")
+	}
+	if !reflect.DeepEqual(unique(current.Tags), unique(desired.Tags)) {
+		upd.Tags = &desired.Tags
+		fields = append(fields, "tags")
+	}
+	if differ(current.Headers, desired.Headers) {
+		upd.Headers = &desired.Headers
+		fields = append(fields, "headers")
+	}
+	if current.Extract != desired.Extract {
+		upd.Extract = &desired.Extract
+		fields = append(fields, "extract")
+	}
+	if differ(current.Ignore, desired.Ignore) {
+		upd.Ignore = &desired.Ignore
+		fields = append(fields, "ignore")
+	}
+	if current.Kind != desired.Kind {
+		upd.Kind = &desired.Kind
+		fields = append(fields, "kind")
+	}
+	if differ(current.ExpectedStatus, desired.ExpectedStatus) {
+		upd.ExpectedStatus = &desired.ExpectedStatus
+		fields = append(fields, "expected_status")
+	}
+	if current.MaxLatency != desired.MaxLatency {
+		upd.MaxLatency = &desired.MaxLatency
+		fields = append(fields, "max_latency")
+	}
+	if current.Timeout != desired.Timeout {
+		upd.Timeout = &desired.Timeout
+		fields = append(fields, "timeout")
+	}
+	if current.Retries != desired.Retries {
+		upd.Retries = &desired.Retries
+		fields = append(fields, "retries")
+	}
+	if current.RetryBackoff != desired.RetryBackoff {
+		upd.RetryBackoff = &desired.RetryBackoff
+		fields = append(fields, "retry_backoff")
+	}
+
+	return &upd, fields
+}
+
+// audit records a change made by the plan. Failures are only logged because
+// the change already happened.
+func (p *Plan) audit(ctx context.Context, action string, entityType string, entityID string, before interface{}, after interface{}) {
+	entry := models.AuditEntry{
+		OrgID:      p.OrgID,
+		ActorEmail: Actor,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Date:       time.Now(),
+	}
+
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+	}
+	if err == nil && after != nil {
+		entry.After, err = json.Marshal(after)
+	}
+	if err == nil {
+		err = p.store.AddAuditEntry(ctx, &entry)
+	}
+	if err != nil {
+		p.logger.Errorf("audit %s %s %s: %v", action, entityType, entityID, err)
+	}
+}
+
+// differ reports whether two maps or slices are different, empty ones being
+// equal to nil ones.
+func differ(current interface{}, desired interface{}) bool {
+	if reflect.ValueOf(current).Len() == 0 && reflect.ValueOf(desired).Len() == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(current, desired)
+}
+
+// unique returns the sorted distinct values, never nil.
+func unique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,247 @@
+// Package events is the bus distributing what happens to checks, such as
+// runs, detected changes and mutations, to the parts of the server that
+// react to them and to the clients following them live.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Types of events.
+const (
+	CheckRun     = "check.run"
+	CheckChanged = "check.changed"
+	CheckCreated = "check.created"
+	CheckUpdated = "check.updated"
+	CheckDeleted = "check.deleted"
+	// CheckDown and CheckRecovered are sent when the page of an
+	// availability check goes down and when it is up again.
+	CheckDown      = "check.down"
+	CheckRecovered = "check.recovered"
+	// CheckFailing is sent when the runs of a check failed as many times
+	// in a row as its failure threshold, CheckResolved when it runs again
+	// after that.
+	CheckFailing  = "check.failing"
+	CheckResolved = "check.resolved"
+	// Reset is sent to a subscriber resuming after events that are no
+	// longer buffered, telling it to reload what it displays.
+	Reset = "reset"
+)
+
+// Event is something that happened to a check. IDs increase by one with
+// every event published and restart when the server does. Tags are the
+// tags of the check at the time of the event.
+type Event struct {
+	ID      uint64      `json:"id"`
+	Type    string      `json:"type"`
+	OrgID   string      `json:"org_id"`
+	CheckID string      `json:"check_id"`
+	Tags    []string    `json:"tags,omitempty"`
+	Date    time.Time   `json:"date"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// subscriptionSize is the number of events a subscriber can lag behind
+// before it is dropped.
+const subscriptionSize = 64
+
+// Subscription receives the events published after it was created. C is
+// closed when the subscriber can't keep up or unsubscribes.
+type Subscription struct {
+	C  <-chan Event
+	ch chan Event
+}
+
+// handler is a consumer of the bus that can't miss events. Its queue has
+// no limit so that a slow handler, such as one sending notifications over
+// the network, never makes Publish wait: falling behind costs memory
+// instead.
+type handler struct {
+	mu      sync.Mutex
+	pending []Event
+	closed  bool
+	// wake is signalled when events are queued or the handler is closed.
+	wake chan struct{}
+	done chan struct{}
+}
+
+// push queues an event for the handler.
+func (h *handler) push(e Event) {
+	h.mu.Lock()
+	h.pending = append(h.pending, e)
+	h.mu.Unlock()
+	h.signal()
+}
+
+// close makes the handler stop once its queue is empty.
+func (h *handler) close() {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+	h.signal()
+}
+
+func (h *handler) signal() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run calls fn with the queued events, in order, until the handler is
+// closed and its queue is empty.
+func (h *handler) run(fn func(Event)) {
+	defer close(h.done)
+	for {
+		h.mu.Lock()
+		queued, closed := h.pending, h.closed
+		h.pending = nil
+		h.mu.Unlock()
+
+		for _, e := range queued {
+			fn(e)
+		}
+		// Nothing is queued once the handler is closed.
+		if closed {
+			return
+		}
+		if len(queued) == 0 {
+			<-h.wake
+		}
+	}
+}
+
+// Broker keeps the latest events in a bounded buffer and sends the new ones
+// to its handlers and subscribers. A nil Broker discards what is published.
+type Broker struct {
+	// publishing serializes Publish so that handlers receive the events
+	// in order while mu is released.
+	publishing  sync.Mutex
+	mu          sync.Mutex
+	buffer      []Event
+	last        uint64
+	subscribers map[*Subscription]struct{}
+	handlers    map[*handler]struct{}
+}
+
+// NewBroker returns a broker buffering up to size events.
+func NewBroker(size int) *Broker {
+	return &Broker{
+		buffer:      make([]Event, size),
+		subscribers: make(map[*Subscription]struct{}),
+		handlers:    make(map[*handler]struct{}),
+	}
+}
+
+// Publish assigns an ID to an event and sends it to the handlers and the
+// subscribers without waiting for any of them. Subscribers whose queue is
+// full are dropped, they can resume from the buffer once they reconnect,
+// while handlers queue every event.
+func (b *Broker) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Date.IsZero() {
+		e.Date = time.Now()
+	}
+
+	b.publishing.Lock()
+	defer b.publishing.Unlock()
+
+	b.mu.Lock()
+	b.last++
+	e.ID = b.last
+	b.buffer[e.ID%uint64(len(b.buffer))] = e
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- e:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+
+	handlers := make([]*handler, 0, len(b.handlers))
+	for h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.Unlock()
+
+	for _, h := range handlers {
+		h.push(e)
+	}
+}
+
+// Handle calls fn with every event published from now on, in order, from a
+// goroutine of its own. The returned function stops the handler after the
+// events it already received are handled.
+func (b *Broker) Handle(fn func(Event)) (stop func()) {
+	h := &handler{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go h.run(fn)
+
+	b.mu.Lock()
+	b.handlers[h] = struct{}{}
+	b.mu.Unlock()
+
+	return func() {
+		// Holding publishing makes sure no event is being queued when
+		// the handler is closed.
+		b.publishing.Lock()
+		b.mu.Lock()
+		_, ok := b.handlers[h]
+		delete(b.handlers, h)
+		b.mu.Unlock()
+		if ok {
+			h.close()
+		}
+		b.publishing.Unlock()
+		<-h.done
+	}
+}
+
+// Subscribe starts receiving events. When after is not zero, the buffered
+// events following it are returned to be handled before the ones received
+// by the subscription. If some of them are no longer buffered, or after is
+// unknown, a single Reset event is returned instead.
+func (b *Broker) Subscribe(after uint64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriptionSize)
+	sub := &Subscription{C: ch, ch: ch}
+	b.subscribers[sub] = struct{}{}
+
+	if after == 0 {
+		return sub, nil
+	}
+
+	oldest := uint64(1)
+	if b.last > uint64(len(b.buffer)) {
+		oldest = b.last - uint64(len(b.buffer)) + 1
+	}
+	if after > b.last || after+1 < oldest {
+		return sub, []Event{{ID: b.last, Type: Reset, Date: time.Now()}}
+	}
+
+	var missed []Event
+	for id := after + 1; id <= b.last; id++ {
+		missed = append(missed, b.buffer[id%uint64(len(b.buffer))])
+	}
+	return sub, missed
+}
+
+// Unsubscribe stops sending events to a subscription.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
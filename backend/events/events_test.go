@@ -0,0 +1,50 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHandleSlow checks that a handler stuck on an event doesn't hold up
+// Publish and that stopping it waits for the events it was sent.
+func TestHandleSlow(t *testing.T) {
+	b := NewBroker(16)
+
+	release := make(chan struct{})
+	var handled []uint64
+	stop := b.Handle(func(e Event) {
+		<-release
+		handled = append(handled, e.ID)
+	})
+
+	const count = 1000
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < count; i++ {
+			b.Publish(Event{Type: CheckRun})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish waited for the handler")
+	}
+
+	close(release)
+	stop()
+	if len(handled) != count {
+		t.Fatalf("%d events handled, want %d", len(handled), count)
+	}
+	for i, id := range handled {
+		if id != uint64(i+1) {
+			t.Fatalf("event %d handled in position %d", id, i)
+		}
+	}
+
+	// The events published after stop are not handled.
+	b.Publish(Event{Type: CheckRun})
+	if len(handled) != count {
+		t.Errorf("an event was handled after stop")
+	}
+}
@@ -0,0 +1,109 @@
+// Package extract turns the body of a page into the content that is
+// monitored, applying the extraction and ignore rules of a check.
+package extract
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+const (
+	RuleExtract = "extract"
+	RuleIgnore  = "ignore"
+)
+
+// Step is a rule applied to the content. Size is the size of the content
+// after the rule.
+type Step struct {
+	Rule    string `json:"rule"`
+	Pattern string `json:"pattern"`
+	Matches int    `json:"matches"`
+	Size    int    `json:"size"`
+}
+
+// Result is the monitored content of a page and how it was obtained.
+// Warnings describe rules that are likely to be mistakes.
+type Result struct {
+	Content  string
+	Steps    []Step
+	Warnings []string
+}
+
+// Rules are the compiled rules of a check.
+type Rules struct {
+	extract *regexp.Regexp
+	ignore  []*regexp.Regexp
+}
+
+// Compile compiles the rules of a check.
+func Compile(check *models.Check) (*Rules, error) {
+	var rules Rules
+	var err error
+
+	if check.Extract != "" {
+		rules.extract, err = regexp.Compile(check.Extract)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extract pattern: %v", err)
+		}
+	}
+
+	for _, pattern := range check.Ignore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %v", pattern, err)
+		}
+		rules.ignore = append(rules.ignore, re)
+	}
+
+	return &rules, nil
+}
+
+// Apply extracts the monitored content from the body of a page, then removes
+// the ignored parts from it.
+func (r *Rules) Apply(body string) Result {
+	result := Result{Content: body, Steps: make([]Step, 0), Warnings: make([]string, 0)}
+
+	if r.extract != nil {
+		matches := r.extract.FindAllStringSubmatch(body, -1)
+		parts := make([]string, len(matches))
+		for i, match := range matches {
+			parts[i] = match[0]
+			if len(match) > 1 {
+				parts[i] = match[1]
+			}
+		}
+		result.Content = strings.Join(parts, "\n")
+		result.Steps = append(result.Steps, Step{
+			Rule:    RuleExtract,
+			Pattern: r.extract.String(),
+			Matches: len(matches),
+			Size:    len(result.Content),
+		})
+		if len(matches) == 0 {
+			result.Warnings = append(result.Warnings, "The extract pattern matches nothing, changes to the page won't be detected")
+		}
+	}
+
+	for _, re := range r.ignore {
+		matches := len(re.FindAllStringIndex(result.Content, -1))
+		result.Content = re.ReplaceAllString(result.Content, "")
+		result.Steps = append(result.Steps, Step{
+			Rule:    RuleIgnore,
+			Pattern: re.String(),
+			Matches: matches,
+			Size:    len(result.Content),
+		})
+		if matches == 0 {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("The ignore pattern %q matches nothing", re.String()))
+		}
+	}
+
+	if body != "" && strings.TrimSpace(result.Content) == "" {
+		result.Warnings = append(result.Warnings, "The monitored content is empty")
+	}
+
+	return result
+}
@@ -2,26 +2,44 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 	"github.com/rs/zerolog"
 	"github.com/samirettali/webmonitor/api"
+	"github.com/samirettali/webmonitor/auth"
+	"github.com/samirettali/webmonitor/cli"
+	"github.com/samirettali/webmonitor/metrics"
 	"github.com/samirettali/webmonitor/middlewares"
 	"github.com/samirettali/webmonitor/monitor"
 	"github.com/samirettali/webmonitor/notifier"
 	"github.com/samirettali/webmonitor/storage"
+	"github.com/samirettali/webmonitor/tracing"
 	"github.com/sirupsen/logrus"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Client commands talk to a running server and need none of its
+	// configuration.
+	if len(os.Args) > 1 && contains(cli.Commands, os.Args[1]) {
+		config, err := cli.LoadConfig()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "webmonitor:", err)
+			os.Exit(1)
+		}
+		os.Exit(cli.Run(os.Args[1:], config, os.Stdout, os.Stderr))
+	}
+
 	log := logrus.New()
 	log.Out = os.Stdout
 	log.Level = logrus.DebugLevel
@@ -38,11 +56,6 @@ func main() {
 	// 	log.Fatal("You must set the WEBHOOK environment variable.")
 	// }
 
-	sender, ok := os.LookupEnv("SENDER_EMAIL")
-	if !ok {
-		log.Fatal("You must set the SENDER_EMAIL environment variable.")
-	}
-
 	postgreURI, ok := os.LookupEnv("POSTGRE_URI")
 	if !ok {
 		log.Fatal("You must set the POSTGRE_URI environment variable.")
@@ -58,24 +71,52 @@ func main() {
 		log.Fatal("You must set the POSTGRE_STATUES_TABLE environment variable.")
 	}
 
+	storage := &storage.PostgreStorage{
+		URI:           postgreURI,
+		ChecksTable:   checksTable,
+		StatusesTable: statusesTable,
+		Logger:        log,
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "apply" {
+		os.Exit(runApply(os.Args[2:], storage, log))
+	}
+
+	sender, ok := os.LookupEnv("SENDER_EMAIL")
+	if !ok {
+		log.Fatal("You must set the SENDER_EMAIL environment variable.")
+	}
 
 	sendgridApiKey, ok := os.LookupEnv("SENDGRID_API_KEY")
 	if !ok {
 		log.Fatal("You must set the SENDGRID_API_KEY environment variable.")
 	}
 
-	storage := &storage.PostgreStorage{
-		URI:    postgreURI,
-		ChecksTable:  checksTable,
-		StatusesTable:  statusesTable,
-		Logger: log,
+	// Traces are exported only when a collector is configured.
+	shutdownTracing := func(context.Context) error { return nil }
+	if endpoint, ok := os.LookupEnv("OTLP_ENDPOINT"); ok {
+		ratio := 1.0
+		if value, ok := os.LookupEnv("TRACE_SAMPLE_RATIO"); ok {
+			ratio, err = strconv.ParseFloat(value, 64)
+			if err != nil || ratio < 0 || ratio > 1 {
+				log.Fatal("TRACE_SAMPLE_RATIO must be a number between 0 and 1.")
+			}
+		}
+		insecure := os.Getenv("OTLP_INSECURE") == "true"
+		shutdownTracing, err = tracing.Setup(context.Background(), endpoint, insecure, ratio)
+		if err != nil {
+			log.Fatal("Could not set up tracing: ", err)
+		}
 	}
 
-	if err != nil {
-		log.Fatal(err)
+	notifier := &notifier.Dispatcher{
+		Email:   notifier.NewEmailNotifier(sender, sendgridApiKey, log),
+		Discord: &notifier.DiscordNotifier{},
 	}
-
-	notifier := notifier.NewEmailNotifier(sender, sendgridApiKey, log)
 	monitor := monitor.NewMonitor(storage, notifier, log)
 
 	if err := monitor.Start(); err != nil {
@@ -85,20 +126,85 @@ func main() {
 
 	defer monitor.Stop()
 
-	handler := api.StorageHandler{Storage: storage, Logger: log}
+	// The admin account owns the default organisation and is the entry point
+	// to invite other users.
+	adminEmail, hasAdminEmail := os.LookupEnv("ADMIN_EMAIL")
+	adminApiKey, hasAdminApiKey := os.LookupEnv("ADMIN_API_KEY")
+	if hasAdminEmail && hasAdminApiKey {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		err = auth.Bootstrap(ctx, storage, adminEmail, adminApiKey)
+		cancel()
+		if err != nil {
+			log.Fatal("Could not create admin: ", err)
+		}
+	}
+
+	// Origins of the dashboard, allowed to call the API from browsers.
+	origins := []string{"http://localhost:3000"}
+
+	handler := &api.StorageHandler{
+		Storage: storage,
+		Monitor: monitor,
+		Logger:  log,
+		Events:  monitor.Events,
+		Origins: origins,
+		Version: 1,
+	}
+	authenticator := &auth.Authenticator{Storage: storage, Logger: log}
+
+	var sso *auth.OIDC
+	if issuer, ok := os.LookupEnv("OIDC_ISSUER"); ok {
+		groupRoles, err := auth.ParseGroupRoles(os.Getenv("OIDC_GROUP_ROLES"))
+		if err != nil {
+			log.Fatal("Invalid OIDC_GROUP_ROLES: ", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		sso, err = auth.NewOIDC(ctx, auth.OIDCConfig{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+			GroupRoles:   groupRoles,
+			AfterLogin:   os.Getenv("OIDC_AFTER_LOGIN_URL"),
+		}, storage, log)
+		cancel()
+		if err != nil {
+			log.Fatal("Could not set up OIDC: ", err)
+		}
+	}
 
 	router := mux.NewRouter().StrictSlash(true)
-	router.HandleFunc("/checks", handler.GetChecks).Methods(http.MethodGet, http.MethodOptions)
-	router.HandleFunc("/checks", handler.CreateCheck).Methods(http.MethodPost, http.MethodOptions)
-	router.HandleFunc("/checks/{id}", handler.GetCheck).Methods(http.MethodGet, http.MethodOptions)
-	router.HandleFunc("/checks/{id}", handler.DeleteCheck).Methods(http.MethodDelete, http.MethodOptions)
-	router.HandleFunc("/checks/{id}", handler.UpdateCheck).Methods(http.MethodPatch, http.MethodOptions)
-	router.HandleFunc("/checks/{id}/history", handler.GetHistory).Methods(http.MethodGet, http.MethodOptions)
 	router.Use(middlewares.Logger)
+	router.Use(middlewares.Metrics)
+	router.Use(middlewares.Tracing)
+
+	if os.Getenv("METRICS_CHECK_LABELS") == "true" {
+		metrics.EnableCheckMetrics()
+	}
+	// Metrics describe the server itself, they are left out of the API.
+	router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+
+	// Probes for supervisors and orchestrators, left out of the API too.
+	router.HandleFunc("/healthz", handler.Healthz).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", handler.Readyz).Methods(http.MethodGet)
+
+	v1 := router.PathPrefix(api.V1Prefix).Subrouter()
+	api.Routes(v1, handler, authenticator, sso)
+
+	// The routes used to be served at the root, where they are kept for the
+	// clients that haven't moved to the versioned paths yet.
+	legacy := router.NewRoute().Subrouter()
+	legacy.Use(middlewares.Deprecated(legacyDeprecated, legacySunset, api.V1Prefix))
+	api.Routes(legacy, handler, authenticator, sso)
 
 	h := cors.New(cors.Options{
-		AllowedOrigins: []string{"http://localhost:3000"},
-		AllowedMethods: []string{"GET", "POST", "DELETE", "PATCH"},
+		AllowedOrigins:   origins,
+		AllowedMethods:   []string{"GET", "POST", "DELETE", "PATCH"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Last-Event-ID", "traceparent", "tracestate", api.OrgHeader},
+		ExposedHeaders:   []string{api.NextCursorHeader, "Deprecation", "Sunset", "Link"},
+		AllowCredentials: true,
 	}).Handler(router)
 
 	srv := &http.Server{
@@ -119,7 +225,7 @@ func main() {
 		}
 	}()
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	<-c
@@ -131,5 +237,25 @@ func main() {
 	if err != nil {
 		log.Error(err)
 	}
+	err = shutdownTracing(ctx)
+	if err != nil {
+		log.Error(err)
+	}
 	os.Exit(0)
 }
+
+// The unversioned routes are deprecated since version 1 of the API was
+// introduced and will be removed at the sunset date.
+var (
+	legacyDeprecated = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacySunset     = time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+)
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
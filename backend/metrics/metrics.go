@@ -0,0 +1,166 @@
+// Package metrics defines the Prometheus metrics describing the server
+// itself, served at /metrics.
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samirettali/webmonitor/utils"
+)
+
+const namespace = "webmonitor"
+
+var fetchBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// checkMetrics is set by EnableCheckMetrics.
+var checkMetrics atomic.Bool
+
+var (
+	// CheckRuns counts the runs of the checks by result, which is one of
+	// unchanged, changed or baseline for content checks, up or down for
+	// availability checks, or error.
+	CheckRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "check_runs_total",
+		Help:      "Runs of the checks by result.",
+	}, []string{"result"})
+
+	Changes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "changes_detected_total",
+		Help:      "Changes detected in the monitored pages.",
+	})
+
+	// FetchErrors counts the pages that couldn't be fetched by the class
+	// returned by ErrorClass.
+	FetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_errors_total",
+		Help:      "Failed fetches of the monitored pages by class of error.",
+	}, []string{"class"})
+
+	// FetchDuration measures the fetches of all the checks together, see
+	// ObserveFetch.
+	FetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Time taken to fetch the pages of the checks.",
+		Buckets:   fetchBuckets,
+	})
+
+	// CheckFetchDuration is labelled by check ID. Every check adds a
+	// series per bucket, so it is only registered by EnableCheckMetrics.
+	// The series of a check are removed when it is deleted.
+	CheckFetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "check_fetch_duration_seconds",
+		Help:      "Time taken to fetch the page of a check.",
+		Buckets:   fetchBuckets,
+	}, []string{"check"})
+
+	// FetchRetries counts the requests made again after a failure that
+	// may not last.
+	FetchRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_retries_total",
+		Help:      "Requests to the monitored pages made again after a retryable failure.",
+	})
+
+	// Notifications counts the notifications by channel type and result,
+	// which is sent or failed. Default notifications have the email type.
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Notifications sent about changes by channel type and result.",
+	}, []string{"type", "result"})
+
+	RunningChecks = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "running_checks",
+		Help:      "Checks being run, bounded by webmonitor_max_running_checks.",
+	})
+
+	MaxRunningChecks = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "max_running_checks",
+		Help:      "Checks that can run at the same time.",
+	})
+
+	// SchedulerLag is the time between the tick of an interval and the
+	// start of one of its checks, which grows when the checks can't keep
+	// up with their schedule.
+	SchedulerLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduler_lag_seconds",
+		Help:      "Delay between the scheduled and the actual start of the checks by interval.",
+		Buckets:   []float64{.001, .01, .1, .5, 1, 5, 15, 60},
+	}, []string{"interval"})
+
+	// RequestDuration is labelled by the path template of the route, the
+	// method and the status code.
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the API requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+// EnableCheckMetrics registers CheckFetchDuration, whose series grow with
+// the number of checks.
+func EnableCheckMetrics() {
+	prometheus.MustRegister(CheckFetchDuration)
+	checkMetrics.Store(true)
+}
+
+// ObserveFetch records the time taken to fetch the page of a check.
+func ObserveFetch(checkID string, d time.Duration) {
+	FetchDuration.Observe(d.Seconds())
+	if checkMetrics.Load() {
+		CheckFetchDuration.WithLabelValues(checkID).Observe(d.Seconds())
+	}
+}
+
+// ForgetCheck removes the series of a deleted check.
+func ForgetCheck(checkID string) {
+	CheckFetchDuration.DeleteLabelValues(checkID)
+}
+
+// ErrorClass classifies an error returned when fetching a page as timeout,
+// dns, connection, tls, request, status or other.
+func ErrorClass(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var urlErr *url.Error
+	var statusErr *utils.StatusError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EHOSTUNREACH):
+		return "connection"
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr):
+		return "tls"
+	case errors.As(err, &urlErr) && urlErr.Op == "parse":
+		return "request"
+	case errors.As(err, &statusErr):
+		return "status"
+	default:
+		return "other"
+	}
+}
@@ -1,28 +1,476 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Kinds of checks. Content checks notify when the monitored content of the
+// page changes, availability checks when the page goes down or recovers.
+const (
+	KindContent      = "content"
+	KindAvailability = "availability"
+)
 
 type Check struct {
-	ID       string `json:"id"`
+	ID    string `json:"id"`
+	OrgID string `json:"org_id" db:"org_id"`
+	// Key is an optional identifier chosen by the user, unique in the
+	// organisation, that configuration files refer to the check by.
+	Key      string `json:"key" validate:"omitempty,max=100"`
 	Name     string `json:"name" validate:"required,min=3,max=30"`
 	URL      string `json:"url" validate:"required,url"`
 	Interval uint64 `json:"interval" validate:"required,min=1"`
 	// Statuses []Status  `json:"-"`
-	Email    string `json:"email" validate:"required,email"`
-	Active   bool   `json:"active" validate:"required"`
+	Email    string   `json:"email" validate:"required,email"`
+	Active   bool     `json:"active"`
+	Channels []string `json:"channels" db:"-"`
+	Tags     []string `json:"tags" db:"-" validate:"dive,min=1,max=50"`
+	GroupID  *string  `json:"group_id" db:"group_id"`
+	// Headers are sent with the requests fetching the page.
+	Headers Headers `json:"headers" db:"headers"`
+	// Extract is a regular expression selecting the monitored parts of the
+	// page. When it has groups, only the first group of every match is
+	// kept.
+	Extract string `json:"extract" db:"extract"`
+	// Ignore are regular expressions whose matches are removed from the
+	// content before comparing it, such as timestamps or counters.
+	Ignore Patterns `json:"ignore" db:"ignore"`
+	// Kind is KindContent or KindAvailability.
+	Kind string `json:"kind" db:"kind" validate:"omitempty,oneof=content availability"`
+	// ExpectedStatus are the status codes an available page answers with,
+	// any code below 400 when empty.
+	ExpectedStatus StatusCodes `json:"expected_status" db:"expected_status" validate:"dive,min=100,max=599"`
+	// MaxLatency is the longest time in milliseconds an available page
+	// takes to answer, unlimited when zero.
+	MaxLatency uint64 `json:"max_latency" db:"max_latency"`
+	// Timeout bounds every request fetching the page, in milliseconds,
+	// 10 seconds when zero.
+	Timeout uint64 `json:"timeout" db:"timeout" validate:"omitempty,min=100,max=30000"`
+	// Retries is the number of times the page is fetched again when the
+	// request times out, the connection is reset or the server answers
+	// with a 5xx status. RetryBackoff is the wait in milliseconds before
+	// the first retry, 1 second when zero, doubled before each following
+	// one.
+	Retries      int    `json:"retries" db:"retries" validate:"min=0,max=3"`
+	RetryBackoff uint64 `json:"retry_backoff" db:"retry_backoff" validate:"max=5000"`
+	// FailureThreshold is the number of consecutive failed runs after which
+	// the failure is notified, DefaultFailureThreshold when zero.
+	FailureThreshold int `json:"failure_threshold" db:"failure_threshold" validate:"omitempty,min=1,max=1000"`
+	// Failures counts the consecutive failed runs, which started at
+	// FailingSince. LastError is the error of the latest failed run, kept
+	// after the check works again.
+	Failures     int        `json:"failures" db:"failures"`
+	FailingSince *time.Time `json:"failing_since" db:"failing_since"`
+	LastError    string     `json:"last_error" db:"last_error"`
+	LastErrorAt  *time.Time `json:"last_error_at" db:"last_error_at"`
+	// LastChanged is the date of the latest status of the check.
+	LastChanged *time.Time `json:"last_changed" db:"last_changed"`
+}
+
+// DefaultFailureThreshold is the failure threshold of the checks that don't
+// set one.
+const DefaultFailureThreshold = 3
+
+// Limits of the fetch settings of the checks.
+const (
+	MaxTimeout      = 30000
+	MaxRetries      = 3
+	MaxRetryBackoff = 5000
+)
+
+// CheckSortFields are the fields checks can be sorted by. Prefixing one with
+// a dash sorts in descending order.
+var CheckSortFields = []string{"name", "url", "interval", "last_changed"}
+
+// CheckFilter selects checks. Zero values match every check.
+type CheckFilter struct {
+	// OrgID is the organisation owning the checks.
+	OrgID    string
+	Active   *bool
+	Interval uint64
+	// Failing selects the checks whose latest run failed, or the ones
+	// whose latest run succeeded.
+	Failing *bool
+	// Search matches a substring of the name or the URL, ignoring case.
+	Search string
+	// ChangedAfter and ChangedBefore restrict the checks to the ones whose
+	// last change happened in [ChangedAfter, ChangedBefore).
+	ChangedAfter  time.Time
+	ChangedBefore time.Time
+	// Tag is the name of a tag the checks must have.
+	Tag     string
+	GroupID string
+	// Sort is one of CheckSortFields, by name if empty.
+	Sort string
+	// AfterValue and AfterID are the sort value and the ID of the last
+	// check of the previous page, if any.
+	AfterValue string
+	AfterID    string
+	Limit      int
 }
 
 type CheckUpdate struct {
-	URL      *string `json:"url" validate:"url"`
-	Name     *string `json:"name" validate:"min=3,max=30"`
-	Interval *uint64 `json:"interval" validate:"min=1"`
-	Email    *string `json:"email" validate:"email"`
-	Active   *bool   `json:"active"`
+	// Key sets the key of the check, an empty string removes it.
+	Key      *string   `json:"key" validate:"omitempty,max=100"`
+	URL      *string   `json:"url" validate:"omitempty,url"`
+	Name     *string   `json:"name" validate:"omitempty,min=3,max=30"`
+	Interval *uint64   `json:"interval" validate:"omitempty,min=1"`
+	Email    *string   `json:"email" validate:"omitempty,email"`
+	Active   *bool     `json:"active"`
+	Channels *[]string `json:"channels"`
+	Tags     *[]string `json:"tags" validate:"omitempty,dive,min=1,max=50"`
+	// GroupID moves the check to another group, an empty string removes it
+	// from its group.
+	GroupID *string   `json:"group_id"`
+	Headers *Headers  `json:"headers"`
+	Extract *string   `json:"extract"`
+	Ignore  *Patterns `json:"ignore"`
+	Kind    *string   `json:"kind" validate:"omitempty,oneof=content availability"`
+
+	ExpectedStatus *StatusCodes `json:"expected_status" validate:"omitempty,dive,min=100,max=599"`
+	MaxLatency     *uint64      `json:"max_latency"`
+
+	Timeout      *uint64 `json:"timeout" validate:"omitempty,eq=0|min=100,max=30000"`
+	Retries      *int    `json:"retries" validate:"omitempty,min=0,max=3"`
+	RetryBackoff *uint64 `json:"retry_backoff" validate:"omitempty,max=5000"`
+
+	FailureThreshold *int `json:"failure_threshold" validate:"omitempty,min=0,max=1000"`
+}
+
+// Headers are HTTP headers, stored as a JSON object.
+type Headers map[string]string
+
+func (h Headers) Value() (driver.Value, error) {
+	if h == nil {
+		return "{}", nil
+	}
+	buf, err := json.Marshal(h)
+	return string(buf), err
+}
+
+func (h *Headers) Scan(src interface{}) error {
+	return scanJSON(src, h)
+}
+
+// Patterns are regular expressions, stored as a JSON array.
+type Patterns []string
+
+func (p Patterns) Value() (driver.Value, error) {
+	if p == nil {
+		return "[]", nil
+	}
+	buf, err := json.Marshal(p)
+	return string(buf), err
+}
+
+func (p *Patterns) Scan(src interface{}) error {
+	return scanJSON(src, p)
+}
+
+// StatusCodes are HTTP status codes, stored as a JSON array.
+type StatusCodes []int
+
+func (c StatusCodes) Value() (driver.Value, error) {
+	if c == nil {
+		return "[]", nil
+	}
+	buf, err := json.Marshal(c)
+	return string(buf), err
+}
+
+func (c *StatusCodes) Scan(src interface{}) error {
+	return scanJSON(src, c)
+}
+
+// Accepts reports whether a page answering with code is available.
+func (c StatusCodes) Accepts(code int) bool {
+	if len(c) == 0 {
+		return code < 400
+	}
+	for _, expected := range c {
+		if code == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func scanJSON(src interface{}, dst interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, dst)
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("can't scan %T as JSON", src)
 }
 
 type Status struct {
-	ID      string `json:"-"`
+	ID      string    `json:"id"`
+	CheckID string    `json:"-" db:"check_id"`
+	Content string    `json:"content"` // TODO byte array maybe
+	Size    int64     `json:"size"`
+	Hash    string    `json:"hash"`
+	Date    time.Time `json:"date"`
+	// Response describes the response the content was read from, it is
+	// missing from the statuses saved before it was recorded.
+	Response *Response `json:"response"`
+}
+
+// Response describes the response a page was fetched with.
+type Response struct {
+	StatusCode int `json:"status_code"`
+	// URL is the URL the response came from after following redirects.
+	URL     string  `json:"url"`
+	Headers Headers `json:"headers"`
+	// ContentLength is the size of the body, which can differ from the
+	// Content-Length header.
+	ContentLength int64  `json:"content_length"`
+	ContentType   string `json:"content_type"`
+	// TLSVersion is empty for plain HTTP.
+	TLSVersion string `json:"tls_version,omitempty"`
+	Timing     Timing `json:"timing"`
+}
+
+// Timing breaks down in milliseconds the time taken to fetch a page. DNS,
+// Connect and TLS add up the lookups, connections and handshakes of every
+// redirect and are zero when a connection was reused. TTFB is the time
+// until the first byte of the final response, Total until its whole body
+// was read.
+type Timing struct {
+	DNSMS     int64 `json:"dns_ms"`
+	ConnectMS int64 `json:"connect_ms"`
+	TLSMS     int64 `json:"tls_ms"`
+	TTFBMS    int64 `json:"ttfb_ms"`
+	TotalMS   int64 `json:"total_ms"`
+}
+
+func (r *Response) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	buf, err := json.Marshal(r)
+	return string(buf), err
+}
+
+func (r *Response) Scan(src interface{}) error {
+	return scanJSON(src, r)
+}
+
+// Probe is the outcome of a run of an availability check.
+type Probe struct {
+	ID      string `json:"id"`
 	CheckID string `json:"-" db:"check_id"`
-	Content string `json:"content"`// TODO byte array maybe
+	Up      bool   `json:"up"`
+	// StatusCode is zero when the page couldn't be fetched.
+	StatusCode int   `json:"status_code" db:"status_code"`
+	DurationMS int64 `json:"duration_ms" db:"duration_ms"`
+	// Reason tells why the page is down.
+	Reason string    `json:"reason,omitempty"`
+	Date   time.Time `json:"date"`
+	// Response holds the timing of the attempt even when the page couldn't
+	// be fetched.
+	Response *Response `json:"response"`
+}
+
+// ProbeFilter selects the probes of a check, newest first.
+type ProbeFilter struct {
+	// From and To restrict the probes to the ones made in [From, To).
+	From time.Time
+	To   time.Time
+	// AfterDate and AfterID are the position of the last probe of the
+	// previous page, if any.
+	AfterDate time.Time
+	AfterID   string
+	Limit     int
+}
+
+// Outcomes of the runs of the checks.
+const (
+	// RunBaseline is the first run of a content check, which saves its
+	// first status.
+	RunBaseline  = "baseline"
+	RunUnchanged = "unchanged"
+	RunChanged   = "changed"
+	// RunUp and RunDown are the outcomes of availability checks.
+	RunUp   = "up"
+	RunDown = "down"
+	// RunTimeout is a run whose page didn't answer in time, RunError one
+	// that failed for another reason.
+	RunTimeout = "timeout"
+	RunError   = "error"
+)
+
+// RunOutcomes are the outcomes of the runs of the checks.
+var RunOutcomes = []string{RunBaseline, RunUnchanged, RunChanged, RunUp, RunDown, RunTimeout, RunError}
+
+// Run records an execution of a check, whatever its outcome. Unlike
+// statuses, which are only saved when the content changes, there is one
+// for every run.
+type Run struct {
+	ID      string    `json:"id"`
+	CheckID string    `json:"-" db:"check_id"`
 	Date    time.Time `json:"date"`
-}
\ No newline at end of file
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+	// DurationMS is the time taken to fetch the page.
+	DurationMS int64 `json:"duration_ms" db:"duration_ms"`
+	StatusCode int   `json:"status_code" db:"status_code"`
+	// Attempts is the number of requests made to fetch the page,
+	// including the retries.
+	Attempts int `json:"attempts"`
+	// Hash is the SHA-256 of the monitored content of content checks.
+	Hash string `json:"hash,omitempty"`
+	// StatusID is the status saved by the run, if any.
+	StatusID string `json:"status_id,omitempty" db:"status_id"`
+}
+
+// RunFilter selects the runs of a check, newest first.
+type RunFilter struct {
+	// Outcome restricts the runs to one of RunOutcomes.
+	Outcome string
+	// From and To restrict the runs to the ones made in [From, To).
+	From time.Time
+	To   time.Time
+	// AfterDate and AfterID are the position of the last run of the
+	// previous page, if any.
+	AfterDate time.Time
+	AfterID   string
+	Limit     int
+}
+
+// Uptime counts the probes of a check over a period and the ones that
+// found it up.
+type Uptime struct {
+	Probes int `db:"probes"`
+	Up     int `db:"up"`
+}
+
+type HistoryFilter struct {
+	// From and To restrict the statuses to the ones saved in [From, To).
+	From time.Time
+	To   time.Time
+	// Ascending lists the oldest statuses first.
+	Ascending bool
+	// AfterDate and AfterID are the position of the last status of the
+	// previous page, if any.
+	AfterDate time.Time
+	AfterID   string
+	// Limit is the maximum number of statuses, unlimited when zero.
+	Limit       int
+	WithContent bool
+}
+
+// Tag labels checks. Checks refer to tags by name and tags are created the
+// first time a check uses them.
+type Tag struct {
+	ID     string `json:"id"`
+	OrgID  string `json:"org_id" db:"org_id"`
+	Name   string `json:"name" validate:"required,min=1,max=50"`
+	Checks int    `json:"checks"`
+}
+
+// Group is a folder checks can be placed in. A check belongs to at most one
+// group.
+type Group struct {
+	ID     string `json:"id"`
+	OrgID  string `json:"org_id" db:"org_id"`
+	Name   string `json:"name" validate:"required,min=1,max=50"`
+	Checks int    `json:"checks"`
+}
+
+// Role is the level of access a user has inside an organisation.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// Allows reports whether r grants at least the permissions of required.
+func (r Role) Allows(required Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[required]
+}
+
+type Organisation struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name" validate:"required,min=3,max=30"`
+	Created time.Time `json:"created"`
+}
+
+type User struct {
+	ID         string    `json:"id"`
+	Email      string    `json:"email" validate:"required,email"`
+	APIKeyHash string    `json:"-" db:"api_key_hash"`
+	Created    time.Time `json:"created"`
+}
+
+// Session is a login obtained through single sign-on. Only the hash of its
+// token is stored.
+type Session struct {
+	TokenHash string    `db:"token_hash"`
+	UserID    string    `db:"user_id"`
+	Expires   time.Time `db:"expires"`
+}
+
+type Membership struct {
+	OrgID   string    `json:"org_id" db:"org_id"`
+	UserID  string    `json:"user_id" db:"user_id"`
+	Email   string    `json:"email" db:"email"`
+	Role    Role      `json:"role" validate:"required,oneof=viewer editor admin"`
+	Created time.Time `json:"created"`
+}
+
+// Channel is a notification destination owned by an organisation that
+// checks can be attached to.
+type Channel struct {
+	ID     string `json:"id"`
+	OrgID  string `json:"org_id" db:"org_id"`
+	Key    string `json:"key" validate:"omitempty,max=100"`
+	Name   string `json:"name" validate:"required,min=3,max=30"`
+	Type   string `json:"type" validate:"required,oneof=email discord"`
+	Target string `json:"target" validate:"required"`
+}
+
+type ChannelUpdate struct {
+	Name   *string `json:"name" validate:"omitempty,min=3,max=30"`
+	Target *string `json:"target" validate:"omitempty,min=1"`
+}
+
+// AuditEntry records a mutation of a check or a channel. Before and After
+// hold the JSON representation of the entity around the change and are
+// null when it was created or deleted respectively.
+type AuditEntry struct {
+	Seq        int64           `json:"seq"`
+	OrgID      string          `json:"org_id" db:"org_id"`
+	ActorID    string          `json:"actor_id" db:"actor_id"`
+	ActorEmail string          `json:"actor_email" db:"actor_email"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type" db:"entity_type"`
+	EntityID   string          `json:"entity_id" db:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	Date       time.Time       `json:"date"`
+}
+
+type AuditFilter struct {
+	EntityID string
+	// Actor matches either the ID or the email of the actor.
+	Actor string
+	// BeforeSeq only returns entries older than the given sequence number.
+	BeforeSeq int64
+	Limit     int
+}
@@ -2,17 +2,30 @@ package monitor
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	stderrors "errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/samirettali/webmonitor/events"
+	"github.com/samirettali/webmonitor/extract"
 	"github.com/samirettali/webmonitor/logger"
+	"github.com/samirettali/webmonitor/metrics"
 	"github.com/samirettali/webmonitor/models"
 	"github.com/samirettali/webmonitor/notifier"
 	"github.com/samirettali/webmonitor/storage"
+	"github.com/samirettali/webmonitor/tracing"
 	"github.com/samirettali/webmonitor/utils"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const TIMEOUT = time.Second * 15
@@ -25,8 +38,21 @@ type Monitor struct {
 	quit     chan struct{}
 	sem      chan struct{}
 	sync.Mutex
+
+	// Events is the bus the results of the runs are published to.
+	// Notifications are sent by one of its consumers.
+	Events *events.Broker
+	// stopHandlers stop the consumers of Events started by Start.
+	stopHandlers []func()
+	// ticks holds the time of the latest tick received by the worker of
+	// each interval, guarded by the embedded mutex.
+	ticks map[uint64]time.Time
 }
 
+// eventBufferSize is the number of events kept for the clients resuming
+// their streams.
+const eventBufferSize = 1000
+
 func NewMonitor(storage storage.Storage, notifier notifier.Notifier, logger logger.Logger) *Monitor {
 	wg := &sync.WaitGroup{}
 	quit := make(chan struct{})
@@ -39,6 +65,9 @@ func NewMonitor(storage storage.Storage, notifier notifier.Notifier, logger logg
 		quit,
 		sem,
 		sync.Mutex{},
+		events.NewBroker(eventBufferSize),
+		nil,
+		nil,
 	}
 }
 
@@ -51,15 +80,33 @@ func (m *Monitor) Start() error {
 		return err
 	}
 
+	m.stopHandlers = append(m.stopHandlers,
+		m.Events.Handle(m.notifyEvent),
+		m.Events.Handle(forgetDeleted),
+	)
+	metrics.MaxRunningChecks.Set(float64(cap(m.sem)))
+
+	m.Lock()
+	m.ticks = make(map[uint64]time.Time, len(INTERVALS))
+	for _, interval := range INTERVALS {
+		m.ticks[interval] = time.Now()
+	}
+	m.Unlock()
+
 	for _, interval := range INTERVALS {
 		go m.worker(interval)
 	}
+	go m.pruneRuns()
 
 	return nil
 }
 
 func (m *Monitor) Stop() {
 	close(m.quit)
+	// Pending notifications are sent before the storage is closed.
+	for _, stop := range m.stopHandlers {
+		stop()
+	}
 	err := m.storage.Close()
 	if err != nil {
 		m.Logger.Error(err)
@@ -74,8 +121,11 @@ func (m *Monitor) worker(interval uint64) {
 
 	for {
 		select {
-		case <-ticker.C:
-			err := m.runChecks(interval)
+		case tick := <-ticker.C:
+			m.Lock()
+			m.ticks[interval] = tick
+			m.Unlock()
+			err := m.runChecks(interval, tick)
 			if err != nil {
 				m.Logger.Error(err)
 			}
@@ -85,11 +135,13 @@ func (m *Monitor) worker(interval uint64) {
 	}
 }
 
-func (m *Monitor) runChecks(interval uint64) error {
+// runChecks runs the active checks of an interval whose tick happened at
+// tick.
+func (m *Monitor) runChecks(interval uint64, tick time.Time) error {
 	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
 	defer cancel()
 
-	checks, err := m.storage.GetChecksByInterval(ctx, interval)
+	checks, err := m.storage.GetActiveChecks(ctx, interval)
 	if err != nil {
 		werr := errors.Wrap(err, "runChecks can't get checks")
 		return werr
@@ -107,19 +159,22 @@ func (m *Monitor) runChecks(interval uint64) error {
 	// errChan := make(chan error)
 	wg.Add(len(checks))
 
-	for _, check := range checks {
+	lag := metrics.SchedulerLag.WithLabelValues(strconv.FormatUint(interval, 10))
+	for i := range checks {
 		m.sem <- struct{}{}
+		metrics.RunningChecks.Inc()
 		go func(check *models.Check) {
-			m.runCheck(check)
+			lag.Observe(time.Since(tick).Seconds())
 			err := m.runCheck(check)
 			if err != nil {
 				// errChan <- err
 				m.Logger.Error(err)
 			}
+			metrics.RunningChecks.Dec()
 			<-m.sem
 			wg.Done()
 			// close(errChan)
-		}(&check)
+		}(&checks[i])
 	}
 	wg.Wait()
 	// close(errChan)
@@ -136,44 +191,694 @@ func (m *Monitor) runChecks(interval uint64) error {
 	return nil
 }
 
+// runRetention is how long the runs of the checks are kept.
+const runRetention = 30 * 24 * time.Hour
+
+// pruneRuns deletes the runs older than runRetention every hour.
+func (m *Monitor) pruneRuns() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+			deleted, err := m.storage.DeleteRuns(ctx, time.Now().Add(-runRetention))
+			cancel()
+			if err != nil {
+				m.Logger.Errorf("prune runs: %v", err)
+				continue
+			}
+			m.Logger.Debugf("pruned %d runs", deleted)
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// schedulerTolerance is how late the tick of an interval can be before the
+// scheduler is considered stuck. Ticks are missed while the checks of the
+// previous one are running, which takes up to TIMEOUT per batch of checks
+// and longer for the ones retrying slow pages.
+var schedulerTolerance = 4*TIMEOUT + maxRunTimeout
+
+// CheckScheduler returns an error if the monitor is not running or the
+// worker of an interval has missed its ticks for longer than the
+// tolerance, meaning it is stuck running checks.
+func (m *Monitor) CheckScheduler(now time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.ticks == nil {
+		return errors.New("the monitor is not running")
+	}
+	for _, interval := range INTERVALS {
+		late := now.Sub(m.ticks[interval]) - time.Duration(interval)*time.Second
+		if late > schedulerTolerance {
+			return errors.Errorf("the checks every %ds are %s late", interval, late.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// CheckNotifier returns an error if the notifier can tell it is not
+// configured properly.
+func (m *Monitor) CheckNotifier() error {
+	if v, ok := m.notifier.(notifier.Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// runMargin is the time a run has for the storage, on top of fetching the
+// page.
+const runMargin = TIMEOUT - utils.DefaultTimeout
+
+// maxRunTimeout is the run timeout of a check with the longest timeout and
+// backoff and the most retries.
+var maxRunTimeout = RunTimeout(&models.Check{
+	Timeout:      models.MaxTimeout,
+	Retries:      models.MaxRetries,
+	RetryBackoff: models.MaxRetryBackoff,
+})
+
+// FetchPolicy returns the timeout and the retries of the requests fetching
+// the page of a check.
+func FetchPolicy(check *models.Check) utils.Policy {
+	return utils.Policy{
+		Timeout: time.Duration(check.Timeout) * time.Millisecond,
+		Retries: check.Retries,
+		Backoff: time.Duration(check.RetryBackoff) * time.Millisecond,
+	}
+}
+
+// RunTimeout returns how long a run of a check can take, fetching its page
+// with every retry and saving the result.
+func RunTimeout(check *models.Check) time.Duration {
+	return FetchPolicy(check).Budget() + runMargin
+}
+
 func (m *Monitor) runCheck(check *models.Check) error {
-	body, err := utils.Request(check.URL)
-	if err != nil {
-		return err
+	ctx, cancel := context.WithTimeout(context.Background(), RunTimeout(check))
+	defer cancel()
+	_, err := m.Run(ctx, check, true)
+	return err
+}
+
+// Result is the outcome of running a check.
+type Result struct {
+	// Changed is true when the content differs from the latest status.
+	// The first run of a check only records a baseline and never counts
+	// as a change.
+	Changed  bool
+	Baseline bool
+	// Notified is true when a notification about the change is sent, which
+	// happens once the result is published.
+	Notified bool
+	// Code is the HTTP status code of the response.
+	Code     int
+	Duration time.Duration
+	// Attempts is the number of requests made to fetch the page,
+	// including the retries.
+	Attempts int
+	// Response describes the response of the page, if it was fetched.
+	Response *models.Response
+	// Hash is the SHA-256 of the monitored content of a content check.
+	Hash string
+	// Run is the record of the run, nil if it couldn't be saved.
+	Run *models.Run
+	// Failures is the number of consecutive failed runs of the check,
+	// including this one, or the number that ended with it if it
+	// succeeded.
+	Failures int
+	// Status is the status saved by the run, if any.
+	Status *models.Status
+	// Diff is the unified diff between the previous content and the new
+	// one.
+	Diff string
+	// Probe is the probe saved by a run of an availability check.
+	Probe *models.Probe
+	// Transition is TransitionDown or TransitionRecovered when the page of
+	// an availability check changed state, in which case Notified tells
+	// whether the transition is notified.
+	Transition string
+}
+
+// Transitions of the pages of availability checks. A page that was never
+// probed counts as up, so a check created for a page that is down notifies
+// it on its first run.
+const (
+	TransitionDown      = "down"
+	TransitionRecovered = "recovered"
+)
+
+// FetchError is returned by Run when the page of the check can't be
+// fetched, after Attempts requests.
+type FetchError struct {
+	Err      error
+	Attempts int
+}
+
+func (e *FetchError) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("can't fetch page after %d attempts: %v", e.Attempts, e.Err)
 	}
+	return "can't fetch page: " + e.Err.Error()
+}
+
+// Run fetches a check, records the run and publishes the result to Events.
+// Content checks save a new status if the monitored content changed,
+// availability checks a probe telling whether the page is up. Changes of
+// content or state are notified if notify is true.
+func (m *Monitor) Run(ctx context.Context, check *models.Check, notify bool) (Result, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "check.run", trace.WithAttributes(
+		attribute.String("check.id", check.ID),
+		attribute.String("org.id", check.OrgID),
+		semconv.URLFull(check.URL),
+	))
+	start := time.Now()
+	result, err := m.run(ctx, check, notify)
+	result.Run = m.record(ctx, check, start, &result, err)
+	result.Failures = m.trackFailures(ctx, check, err)
+	span.SetAttributes(
+		attribute.Bool("check.changed", result.Changed),
+		attribute.Bool("check.baseline", result.Baseline),
+	)
+	if result.Probe != nil {
+		span.SetAttributes(attribute.Bool("check.up", result.Probe.Up))
+	}
+	tracing.End(span, err)
 
-	getCtx, getCancel := context.WithTimeout(context.Background(), TIMEOUT)
-	defer getCancel()
-	latestStatus, err := m.storage.GetStatus(getCtx, check.ID)
+	observe(check, &result, err)
+	m.publish(check, &result, err, notify, span.SpanContext())
+	return result, err
+}
+
+// record saves the run of a check. Runs that timed out are saved too, so it
+// doesn't use the deadline of the run.
+func (m *Monitor) record(ctx context.Context, check *models.Check, start time.Time, result *Result, err error) *models.Run {
+	run := models.Run{
+		ID:         uuid.NewString(),
+		CheckID:    check.ID,
+		Date:       start,
+		Outcome:    outcome(result, err),
+		DurationMS: result.Duration.Milliseconds(),
+		StatusCode: result.Code,
+		Attempts:   result.Attempts,
+		Hash:       result.Hash,
+	}
 	if err != nil {
-		return errors.Wrap(err, "can't get latest status")
+		// Failed runs take as long as it took to give up.
+		run.Error = err.Error()
+		run.DurationMS = time.Since(start).Milliseconds()
+	}
+	if result.Status != nil {
+		run.StatusID = result.Status.ID
 	}
 
-	if body == latestStatus.Content {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), TIMEOUT)
+	defer cancel()
+	if err := m.storage.AddRun(ctx, &run); err != nil {
+		m.Logger.Errorf("save run of check %s: %v", check.ID, err)
 		return nil
 	}
+	return &run
+}
+
+// trackFailures updates the consecutive failures of a check after a run and
+// returns them, see Result.Failures. Like record, it runs even after the
+// deadline of the run.
+func (m *Monitor) trackFailures(ctx context.Context, check *models.Check, runErr error) int {
+	// Checks that weren't failing are not updated when they succeed,
+	// which is most of the time.
+	if runErr == nil && check.Failures == 0 {
+		return 0
+	}
 
-	fmt.Println(body, latestStatus.Content)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), TIMEOUT)
+	defer cancel()
 
-	err = m.notifier.Notify(check)
+	var failures int
+	var err error
+	if runErr != nil {
+		failures, err = m.storage.RecordFailure(ctx, check.ID, runErr.Error(), time.Now())
+	} else {
+		failures, err = m.storage.ClearFailures(ctx, check.ID)
+	}
 	if err != nil {
-		return errors.Wrap(err, "can't sent notification")
+		m.Logger.Errorf("track failures of check %s: %v", check.ID, err)
+		return 0
 	}
+	return failures
+}
 
-	upd := models.Status{
-		ID:      uuid.NewString(),
+// failureThreshold returns the number of consecutive failures after which
+// a check is failing.
+func failureThreshold(check *models.Check) int {
+	if check.FailureThreshold > 0 {
+		return check.FailureThreshold
+	}
+	return models.DefaultFailureThreshold
+}
+
+// outcome returns the outcome of a run, one of models.RunOutcomes.
+func outcome(result *Result, err error) string {
+	if fetchErr, ok := err.(*FetchError); ok && metrics.ErrorClass(fetchErr.Err) == "timeout" {
+		return models.RunTimeout
+	}
+
+	switch {
+	case err != nil:
+		return models.RunError
+	case result.Probe != nil && result.Probe.Up:
+		return models.RunUp
+	case result.Probe != nil:
+		return models.RunDown
+	case result.Baseline:
+		return models.RunBaseline
+	case result.Changed:
+		return models.RunChanged
+	default:
+		return models.RunUnchanged
+	}
+}
+
+// observe records the outcome of a run in the metrics.
+func observe(check *models.Check, result *Result, err error) {
+	if result.Attempts > 1 {
+		metrics.FetchRetries.Add(float64(result.Attempts - 1))
+	}
+
+	fetchErr, isFetchErr := err.(*FetchError)
+	switch {
+	case isFetchErr:
+		metrics.CheckRuns.WithLabelValues("error").Inc()
+		metrics.FetchErrors.WithLabelValues(metrics.ErrorClass(fetchErr.Err)).Inc()
+		return
+	case err != nil:
+		metrics.CheckRuns.WithLabelValues("error").Inc()
+		return
+	}
+
+	if result.Probe != nil {
+		if result.Probe.StatusCode != 0 {
+			metrics.ObserveFetch(check.ID, result.Duration)
+		}
+		if result.Probe.Up {
+			metrics.CheckRuns.WithLabelValues("up").Inc()
+		} else {
+			metrics.CheckRuns.WithLabelValues("down").Inc()
+		}
+		return
+	}
+
+	metrics.ObserveFetch(check.ID, result.Duration)
+	switch {
+	case result.Baseline:
+		metrics.CheckRuns.WithLabelValues("baseline").Inc()
+	case result.Changed:
+		metrics.CheckRuns.WithLabelValues("changed").Inc()
+		metrics.Changes.Inc()
+	default:
+		metrics.CheckRuns.WithLabelValues("unchanged").Inc()
+	}
+}
+
+// forgetDeleted is the consumer of Events removing the metrics of the
+// deleted checks.
+func forgetDeleted(e events.Event) {
+	if e.Type == events.CheckDeleted {
+		metrics.ForgetCheck(e.CheckID)
+	}
+}
+
+// runEvent is the data of a CheckRun event.
+type runEvent struct {
+	Changed    bool   `json:"changed"`
+	Baseline   bool   `json:"baseline"`
+	Notified   bool   `json:"notified"`
+	StatusCode int    `json:"status_code"`
+	DurationMS int64  `json:"duration_ms"`
+	StatusID   string `json:"status_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+	RunID      string `json:"run_id,omitempty"`
+	Attempts   int    `json:"attempts"`
+	Failures   int    `json:"failures,omitempty"`
+	// Up, ProbeID and Reason are set by availability checks.
+	Up      *bool  `json:"up,omitempty"`
+	ProbeID string `json:"probe_id,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// changeEvent is the data of a CheckChanged event. Check, Notify and Span,
+// the span of the run, are for the notifier and are not sent to the
+// clients.
+type changeEvent struct {
+	StatusID string            `json:"status_id"`
+	Diff     string            `json:"diff"`
+	Check    *models.Check     `json:"-"`
+	Notify   bool              `json:"-"`
+	Span     trace.SpanContext `json:"-"`
+}
+
+// stateEvent is the data of the CheckDown and CheckRecovered events.
+type stateEvent struct {
+	ProbeID    string            `json:"probe_id"`
+	StatusCode int               `json:"status_code"`
+	Reason     string            `json:"reason,omitempty"`
+	Check      *models.Check     `json:"-"`
+	Notify     bool              `json:"-"`
+	Span       trace.SpanContext `json:"-"`
+}
+
+// failureEvent is the data of the CheckFailing and CheckResolved events.
+// Error is the error of the latest failed run.
+type failureEvent struct {
+	Failures int               `json:"failures"`
+	Error    string            `json:"error,omitempty"`
+	Check    *models.Check     `json:"-"`
+	Notify   bool              `json:"-"`
+	Span     trace.SpanContext `json:"-"`
+}
+
+// publish sends the events describing the outcome of a run.
+func (m *Monitor) publish(check *models.Check, result *Result, err error, notify bool, span trace.SpanContext) {
+	run := runEvent{
+		Changed:    result.Changed,
+		Baseline:   result.Baseline,
+		Notified:   result.Notified,
+		StatusCode: result.Code,
+		DurationMS: result.Duration.Milliseconds(),
+		Attempts:   result.Attempts,
+	}
+	if err != nil {
+		run.Failures = result.Failures
+	}
+	if result.Status != nil {
+		run.StatusID = result.Status.ID
+	}
+	if result.Run != nil {
+		run.RunID = result.Run.ID
+	}
+	if result.Probe != nil {
+		run.Up = &result.Probe.Up
+		run.ProbeID = result.Probe.ID
+		run.Reason = result.Probe.Reason
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	m.Events.Publish(events.Event{
+		Type:    events.CheckRun,
+		OrgID:   check.OrgID,
 		CheckID: check.ID,
-		Content: body,
-		Date:    time.Now(),
+		Tags:    check.Tags,
+		Data:    run,
+	})
+
+	if err == nil && result.Changed {
+		m.Events.Publish(events.Event{
+			Type:    events.CheckChanged,
+			OrgID:   check.OrgID,
+			CheckID: check.ID,
+			Tags:    check.Tags,
+			Data: changeEvent{
+				StatusID: result.Status.ID,
+				Diff:     result.Diff,
+				Check:    check,
+				Notify:   result.Notified,
+				Span:     span,
+			},
+		})
 	}
 
-	updCtx, updCancel := context.WithTimeout(context.Background(), TIMEOUT)
-	defer updCancel()
+	// The failure is notified once, when it reaches the threshold, and so
+	// is the end of a failure that was notified.
+	threshold := failureThreshold(check)
+	switch {
+	case err != nil && result.Failures == threshold:
+		m.Events.Publish(events.Event{
+			Type:    events.CheckFailing,
+			OrgID:   check.OrgID,
+			CheckID: check.ID,
+			Tags:    check.Tags,
+			Data: failureEvent{
+				Failures: result.Failures,
+				Error:    err.Error(),
+				Check:    check,
+				Notify:   notify,
+				Span:     span,
+			},
+		})
+	case err == nil && result.Failures >= threshold:
+		m.Events.Publish(events.Event{
+			Type:    events.CheckResolved,
+			OrgID:   check.OrgID,
+			CheckID: check.ID,
+			Tags:    check.Tags,
+			Data: failureEvent{
+				Failures: result.Failures,
+				Check:    check,
+				Notify:   notify,
+				Span:     span,
+			},
+		})
+	}
 
-	err = m.storage.UpdateStatus(updCtx, check.ID, &upd)
+	if err == nil && result.Transition != "" {
+		eventType := events.CheckDown
+		if result.Transition == TransitionRecovered {
+			eventType = events.CheckRecovered
+		}
+		m.Events.Publish(events.Event{
+			Type:    eventType,
+			OrgID:   check.OrgID,
+			CheckID: check.ID,
+			Tags:    check.Tags,
+			Data: stateEvent{
+				ProbeID:    result.Probe.ID,
+				StatusCode: result.Probe.StatusCode,
+				Reason:     result.Probe.Reason,
+				Check:      check,
+				Notify:     result.Notified,
+				Span:       span,
+			},
+		})
+	}
+}
+
+// notifyEvent is the consumer of Events sending the notifications about
+// changes of content and of state, and about checks failing and working
+// again.
+func (m *Monitor) notifyEvent(e events.Event) {
+	var check *models.Check
+	var msg notifier.Message
+	var span trace.SpanContext
+
+	switch data := e.Data.(type) {
+	case changeEvent:
+		if !data.Notify {
+			return
+		}
+		check, msg, span = data.Check, notifier.ChangeMessage(data.Check), data.Span
+	case stateEvent:
+		if !data.Notify {
+			return
+		}
+		check, msg, span = data.Check, notifier.RecoveredMessage(data.Check), data.Span
+		if e.Type == events.CheckDown {
+			msg = notifier.DownMessage(data.Check, data.Reason)
+		}
+	case failureEvent:
+		if !data.Notify {
+			return
+		}
+		check, msg, span = data.Check, notifier.ResolvedMessage(data.Check, data.Failures), data.Span
+		if e.Type == events.CheckFailing {
+			msg = notifier.FailingMessage(data.Check, data.Failures, data.Error)
+		}
+	default:
+		return
+	}
+
+	// The notification belongs to the trace of the run that detected the
+	// change.
+	ctx := trace.ContextWithSpanContext(context.Background(), span)
+	err := m.notify(ctx, check, msg)
 	if err != nil {
-		return errors.Wrap(err, "can't update status")
+		m.Logger.Errorf("notify check %s: %v", e.CheckID, err)
 	}
+}
 
-	return nil
+func (m *Monitor) run(ctx context.Context, check *models.Check, notify bool) (Result, error) {
+	if check.Kind == models.KindAvailability {
+		return m.probe(ctx, check, notify)
+	}
+
+	rules, err := extract.Compile(check)
+	if err != nil {
+		return Result{}, err
+	}
+
+	resp, err := utils.Fetch(ctx, check.URL, check.Headers, FetchPolicy(check))
+	if err != nil {
+		return Result{Attempts: resp.Attempts}, &FetchError{Err: err, Attempts: resp.Attempts}
+	}
+	// A server error that outlasted the retries fails the run rather than
+	// becoming the content of the page.
+	if resp.StatusCode >= 500 {
+		result := Result{Code: resp.StatusCode, Duration: resp.Duration, Attempts: resp.Attempts, Response: resp.Info}
+		return result, &FetchError{Err: &utils.StatusError{Code: resp.StatusCode}, Attempts: resp.Attempts}
+	}
+	content := rules.Apply(resp.Body).Content
+	sum := sha256.Sum256([]byte(content))
+
+	result := Result{Code: resp.StatusCode, Duration: resp.Duration, Attempts: resp.Attempts, Response: resp.Info, Hash: hex.EncodeToString(sum[:])}
+
+	latestStatus, err := m.storage.GetStatus(ctx, check.ID)
+	// Checks that were saved without a first status, such as imported ones,
+	// get it on their first run without notifying anyone.
+	result.Baseline = err == sql.ErrNoRows
+	if err != nil && !result.Baseline {
+		return Result{}, errors.Wrap(err, "can't get latest status")
+	}
+
+	if !result.Baseline && content == latestStatus.Content {
+		return result, nil
+	}
+
+	if !result.Baseline {
+		result.Changed = true
+		result.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(latestStatus.Content),
+			B:        difflib.SplitLines(content),
+			FromFile: latestStatus.ID,
+			ToFile:   "current",
+			Context:  3,
+		})
+		if err != nil {
+			return Result{}, err
+		}
+
+		result.Notified = notify
+	}
+
+	upd := models.Status{
+		ID:       uuid.NewString(),
+		CheckID:  check.ID,
+		Content:  content,
+		Date:     time.Now(),
+		Response: resp.Info,
+	}
+
+	err = m.storage.UpdateStatus(ctx, check.ID, &upd)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "can't update status")
+	}
+	result.Status = &upd
+
+	return result, nil
+}
+
+// probe fetches the page of an availability check, saves whether it is up
+// and compares it to the previous probe. A page that can't be fetched is
+// down rather than an error.
+func (m *Monitor) probe(ctx context.Context, check *models.Check, notify bool) (Result, error) {
+	probe := models.Probe{ID: uuid.NewString(), CheckID: check.ID}
+
+	resp, err := utils.Fetch(ctx, check.URL, check.Headers, FetchPolicy(check))
+	probe.Date = time.Now()
+	probe.Response = resp.Info
+	if err != nil {
+		probe.Reason = err.Error()
+	} else {
+		probe.StatusCode = resp.StatusCode
+		probe.DurationMS = resp.Duration.Milliseconds()
+		probe.Reason = assess(check, &resp)
+	}
+	probe.Up = probe.Reason == ""
+
+	result := Result{Code: resp.StatusCode, Duration: resp.Duration, Attempts: resp.Attempts, Response: resp.Info, Probe: &probe}
+
+	wasUp := true
+	previous, err := m.storage.GetLatestProbe(ctx, check.ID)
+	if err == nil {
+		wasUp = previous.Up
+	} else if err != sql.ErrNoRows {
+		return Result{}, errors.Wrap(err, "can't get latest probe")
+	}
+
+	err = m.storage.AddProbe(ctx, &probe)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "can't add probe")
+	}
+
+	switch {
+	case wasUp && !probe.Up:
+		result.Transition = TransitionDown
+	case !wasUp && probe.Up:
+		result.Transition = TransitionRecovered
+	}
+	result.Notified = notify && result.Transition != ""
+
+	return result, nil
+}
+
+// assess returns why the response of an availability check means that the
+// page is down, or an empty string if it is up.
+func assess(check *models.Check, resp *utils.Response) string {
+	if !check.ExpectedStatus.Accepts(resp.StatusCode) {
+		return fmt.Sprintf("unexpected status code %d", resp.StatusCode)
+	}
+	if check.MaxLatency > 0 && resp.Duration > time.Duration(check.MaxLatency)*time.Millisecond {
+		return fmt.Sprintf("answered in %dms, more than %dms", resp.Duration.Milliseconds(), check.MaxLatency)
+	}
+	return ""
+}
+
+// notify alerts the default recipient and every channel of a check.
+func (m *Monitor) notify(ctx context.Context, check *models.Check, msg notifier.Message) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "notify", trace.WithAttributes(attribute.String("check.id", check.ID)))
+	defer func() { tracing.End(span, err) }()
+
+	// A failure to send to a channel doesn't keep the others from being
+	// notified, the errors are returned together.
+	var errs []error
+	_, sendSpan := tracing.Tracer().Start(ctx, "notify.send", trace.WithAttributes(attribute.String("channel.type", "email")))
+	sendErr := m.notifier.Notify(check, msg)
+	tracing.End(sendSpan, sendErr)
+	countNotification("email", sendErr)
+	if sendErr != nil {
+		errs = append(errs, errors.Wrap(sendErr, "can't sent notification"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, TIMEOUT)
+	defer cancel()
+	channels, chErr := m.storage.GetCheckChannels(ctx, check.ID)
+	if chErr != nil {
+		errs = append(errs, errors.Wrap(chErr, "can't get channels"))
+	}
+
+	for i := range channels {
+		_, sendSpan := tracing.Tracer().Start(ctx, "notify.send", trace.WithAttributes(
+			attribute.String("channel.type", channels[i].Type),
+			attribute.String("channel.id", channels[i].ID),
+		))
+		err := m.notifier.NotifyChannel(&channels[i], check, msg)
+		tracing.End(sendSpan, err)
+		countNotification(channels[i].Type, err)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "can't notify channel %s", channels[i].ID))
+		}
+	}
+
+	return stderrors.Join(errs...)
+}
+
+func countNotification(channelType string, err error) {
+	result := "sent"
+	if err != nil {
+		result = "failed"
+	}
+	metrics.Notifications.WithLabelValues(channelType, result).Inc()
 }
@@ -0,0 +1,224 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/notifier"
+	"github.com/samirettali/webmonitor/storage"
+	"github.com/samirettali/webmonitor/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// memoryStorage keeps the statuses and runs of a check in memory. Like
+// storage.PostgreStorage, it starts a span for every call so that the
+// tests can tell whether the monitor passes the context of the run along.
+type memoryStorage struct {
+	storage.Storage
+
+	mu       sync.Mutex
+	statuses []models.Status
+	runs     []models.Run
+	channels []models.Channel
+}
+
+func (s *memoryStorage) span(ctx context.Context, method string) trace.Span {
+	_, span := tracing.Tracer().Start(ctx, "storage."+method, trace.WithSpanKind(trace.SpanKindClient))
+	return span
+}
+
+func (s *memoryStorage) GetStatus(ctx context.Context, checkID string) (models.Status, error) {
+	defer s.span(ctx, "GetStatus").End()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.statuses) == 0 {
+		return models.Status{}, fmt.Errorf("no status")
+	}
+	return s.statuses[len(s.statuses)-1], nil
+}
+
+func (s *memoryStorage) UpdateStatus(ctx context.Context, checkID string, status *models.Status) error {
+	defer s.span(ctx, "UpdateStatus").End()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = append(s.statuses, *status)
+	return nil
+}
+
+func (s *memoryStorage) AddRun(ctx context.Context, run *models.Run) error {
+	defer s.span(ctx, "AddRun").End()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs = append(s.runs, *run)
+	return nil
+}
+
+func (s *memoryStorage) GetCheckChannels(ctx context.Context, checkID string) ([]models.Channel, error) {
+	defer s.span(ctx, "GetCheckChannels").End()
+	return s.channels, nil
+}
+
+// recordingNotifier counts the notifications it is asked to send.
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent int
+}
+
+func (n *recordingNotifier) Notify(check *models.Check, msg notifier.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent++
+	return nil
+}
+
+func (n *recordingNotifier) NotifyChannel(channel *models.Channel, check *models.Check, msg notifier.Message) error {
+	return n.Notify(check, msg)
+}
+
+// failingEmail fails to alert the default recipient of a check and
+// counts the notifications sent to its channels.
+type failingEmail struct {
+	recordingNotifier
+}
+
+func (n *failingEmail) Notify(check *models.Check, msg notifier.Message) error {
+	return errors.New("mail server unavailable")
+}
+
+// installExporter sends the spans of every trace to an in-memory exporter
+// until the end of the test.
+func installExporter(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), 1)
+	previous := otel.GetTracerProvider()
+	tracing.Install(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		tracing.Install(previous)
+	})
+	return exporter
+}
+
+func TestRunSpans(t *testing.T) {
+	exporter := installExporter(t)
+
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "new content")
+	}))
+	defer page.Close()
+
+	store := &memoryStorage{
+		statuses: []models.Status{{ID: "s1", CheckID: "c1", Content: "old content", Date: time.Now()}},
+		channels: []models.Channel{{ID: "ch1", Type: "discord"}},
+	}
+	notifications := &recordingNotifier{}
+	m := NewMonitor(store, notifications, logrus.New())
+	stop := m.Events.Handle(m.notifyEvent)
+
+	check := &models.Check{ID: "c1", OrgID: "o1", Name: "Page", URL: page.URL, Interval: 60, Email: "ops@example.com", Active: true}
+	result, err := m.Run(context.Background(), check, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Changed || !result.Notified {
+		t.Fatalf("run changed %v and notified %v, want both", result.Changed, result.Notified)
+	}
+	// Stopping the consumer waits for the notifications to be sent.
+	stop()
+	if notifications.sent != 2 {
+		t.Errorf("%d notifications sent, want 2", notifications.sent)
+	}
+
+	spans := exporter.GetSpans()
+	byID := make(map[trace.SpanID]tracetest.SpanStub, len(spans))
+	var root *tracetest.SpanStub
+	for i := range spans {
+		byID[spans[i].SpanContext.SpanID()] = spans[i]
+		if spans[i].Name == "check.run" {
+			root = &spans[i]
+		}
+	}
+	if root == nil {
+		t.Fatalf("no check.run span among %d spans", len(spans))
+	}
+	if root.Parent.IsValid() {
+		t.Errorf("check.run has a parent")
+	}
+
+	// path returns the names of the ancestors of a span up to the root of
+	// its trace, the span itself excluded.
+	path := func(span tracetest.SpanStub) []string {
+		var names []string
+		for span.Parent.IsValid() {
+			parent, ok := byID[span.Parent.SpanID()]
+			if !ok {
+				return append(names, "?")
+			}
+			names = append(names, parent.Name)
+			span = parent
+		}
+		return names
+	}
+
+	want := map[string][]string{
+		"HTTP GET":                 {"check.run"},
+		"storage.GetStatus":        {"check.run"},
+		"storage.UpdateStatus":     {"check.run"},
+		"storage.AddRun":           {"check.run"},
+		"notify":                   {"check.run"},
+		"storage.GetCheckChannels": {"notify", "check.run"},
+		"notify.send":              {"notify", "check.run"},
+	}
+	seen := make(map[string]int)
+	for _, span := range spans {
+		if span.Name == "check.run" {
+			continue
+		}
+		seen[span.Name]++
+		ancestors, ok := want[span.Name]
+		if !ok {
+			t.Errorf("unexpected span %s", span.Name)
+			continue
+		}
+		if fmt.Sprint(path(span)) != fmt.Sprint(ancestors) {
+			t.Errorf("span %s descends from %v, want %v", span.Name, path(span), ancestors)
+		}
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("span %s belongs to another trace", span.Name)
+		}
+	}
+	for name := range want {
+		if seen[name] == 0 {
+			t.Errorf("no %s span", name)
+		}
+	}
+	// The default recipient and the channel are notified separately.
+	if seen["notify.send"] != 2 {
+		t.Errorf("%d notify.send spans, want 2", seen["notify.send"])
+	}
+}
+
+func TestNotifyEmailFailure(t *testing.T) {
+	store := &memoryStorage{channels: []models.Channel{{ID: "ch1", Type: "discord"}, {ID: "ch2", Type: "discord"}}}
+	notifications := &failingEmail{}
+	m := NewMonitor(store, notifications, logrus.New())
+
+	err := m.notify(context.Background(), &models.Check{ID: "c1", Email: "ops@example.com"}, notifier.Message{Subject: "Page changed"})
+	if err == nil {
+		t.Error("the failed email wasn't reported")
+	}
+	if notifications.sent != 2 {
+		t.Errorf("%d channels notified, want 2", notifications.sent)
+	}
+}
@@ -3,6 +3,8 @@ package notifier
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -10,7 +12,16 @@ import (
 )
 
 type Notifier interface {
-	Notify(check *models.Check) error
+	// Notify alerts the default recipient of a check.
+	Notify(check *models.Check, msg Message) error
+	// NotifyChannel alerts one of the channels attached to a check.
+	NotifyChannel(channel *models.Channel, check *models.Check, msg Message) error
+}
+
+// Validator is implemented by the notifiers that can tell whether they are
+// configured to send notifications.
+type Validator interface {
+	Validate() error
 }
 
 type DiscordNotifier struct {
@@ -24,6 +35,16 @@ func (d *DiscordNotifier) Notify(text string, destination string) error {
 	payload := map[string]string{"content": text}
 
 	jsonValue, _ := json.Marshal(payload)
-	_, err := client.Post(destination, "application/json", bytes.NewBuffer(jsonValue))
-	return err
+	resp, err := client.Post(destination, "application/json", bytes.NewBuffer(jsonValue))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Draining the body lets the connection be reused.
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("discord answered with status %d", resp.StatusCode)
+	}
+	return nil
 }
@@ -0,0 +1,41 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+// Dispatcher routes notifications to the service matching each channel
+// type. Default notifications go to the email of the check.
+type Dispatcher struct {
+	Email   *EmailNotifier
+	Discord *DiscordNotifier
+}
+
+func (d *Dispatcher) Notify(check *models.Check, msg Message) error {
+	return d.Email.Notify(check, msg)
+}
+
+// Validate checks that every service is configured.
+func (d *Dispatcher) Validate() error {
+	if d.Email == nil {
+		return errors.New("email notifications are not configured")
+	}
+	if d.Discord == nil {
+		return errors.New("discord notifications are not configured")
+	}
+	return d.Email.Validate()
+}
+
+func (d *Dispatcher) NotifyChannel(channel *models.Channel, check *models.Check, msg Message) error {
+	switch channel.Type {
+	case "email":
+		return d.Email.NotifyChannel(channel, check, msg)
+	case "discord":
+		return d.Discord.Notify(msg.Text, channel.Target)
+	default:
+		return fmt.Errorf("unknown channel type %s", channel.Type)
+	}
+}
@@ -1,7 +1,9 @@
 package notifier
 
 import (
+	"errors"
 	"fmt"
+	netmail "net/mail"
 
 	"github.com/samirettali/webmonitor/logger"
 	"github.com/samirettali/webmonitor/models"
@@ -12,6 +14,7 @@ import (
 type EmailNotifier struct {
 	sender *mail.Email
 	client *sendgrid.Client
+	apiKey string
 	Logger logger.Logger
 }
 
@@ -19,17 +22,39 @@ func NewEmailNotifier(sender string, apiKey string, logger logger.Logger) *Email
 	return &EmailNotifier{
 		sender: mail.NewEmail("WebMonitor", sender),
 		client: sendgrid.NewSendClient(apiKey),
+		apiKey: apiKey,
 		Logger: logger,
 	}
 }
 
-func (e *EmailNotifier) Notify(check *models.Check) error {
-	text := buildMessage(check)
-	subject := fmt.Sprintf("WebMonitor alert: %s", check.URL)
-	to := mail.NewEmail(check.Email, check.Email)
-	message := mail.NewSingleEmail(e.sender, subject, to, text, "")
+// Validate checks that the sender is a valid address and that there is an
+// API key.
+func (e *EmailNotifier) Validate() error {
+	if _, err := netmail.ParseAddress(e.sender.Address); err != nil {
+		return fmt.Errorf("invalid sender email %q: %v", e.sender.Address, err)
+	}
+	if e.apiKey == "" {
+		return errors.New("the SendGrid API key is empty")
+	}
+	return nil
+}
+
+func (e *EmailNotifier) Notify(check *models.Check, msg Message) error {
+	return e.send(check.Email, msg)
+}
+
+func (e *EmailNotifier) NotifyChannel(channel *models.Channel, check *models.Check, msg Message) error {
+	if channel.Type != "email" {
+		return fmt.Errorf("email notifier can't handle %s channels", channel.Type)
+	}
+	return e.send(channel.Target, msg)
+}
+
+func (e *EmailNotifier) send(address string, msg Message) error {
+	to := mail.NewEmail(address, address)
+	message := mail.NewSingleEmail(e.sender, msg.Subject, to, msg.Text, "")
 	// _, err := e.client.Send(message)
 	// return err
-	e.Logger.Infof("Sent notification to %s for %+v\n", check.Email, message.Sections)
+	e.Logger.Infof("Sent notification to %s for %+v\n", address, message.Sections)
 	return nil
 }
@@ -1,11 +1,60 @@
 package notifier
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/samirettali/webmonitor/models"
 )
 
+// Message is what a notification says about a check.
+type Message struct {
+	Subject string
+	Text    string
+}
+
+// ChangeMessage tells that the content of a check changed.
+func ChangeMessage(check *models.Check) Message {
+	return Message{
+		Subject: "WebMonitor alert: " + check.URL,
+		Text:    buildMessage(check),
+	}
+}
+
+// DownMessage tells that the page of an availability check is down.
+func DownMessage(check *models.Check, reason string) Message {
+	return Message{
+		Subject: "WebMonitor alert: " + check.URL + " is down",
+		Text:    check.URL + " is down: " + reason,
+	}
+}
+
+// RecoveredMessage tells that the page of an availability check is up
+// again.
+func RecoveredMessage(check *models.Check) Message {
+	return Message{
+		Subject: "WebMonitor: " + check.URL + " recovered",
+		Text:    check.URL + " is up again",
+	}
+}
+
+// FailingMessage tells that the runs of a check keep failing, so that it is
+// no longer monitored.
+func FailingMessage(check *models.Check, failures int, lastError string) Message {
+	return Message{
+		Subject: "WebMonitor alert: can't check " + check.URL,
+		Text:    fmt.Sprintf("The last %d runs of the check of %s failed: %s", failures, check.URL, lastError),
+	}
+}
+
+// ResolvedMessage tells that a check that kept failing runs again.
+func ResolvedMessage(check *models.Check, failures int) Message {
+	return Message{
+		Subject: "WebMonitor: " + check.URL + " is monitored again",
+		Text:    fmt.Sprintf("The check of %s runs again after %d failed runs", check.URL, failures),
+	}
+}
+
 func buildMessage(check *models.Check) string {
 	b := strings.Builder{}
 	b.WriteString("Detected difference on ")
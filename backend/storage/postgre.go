@@ -2,7 +2,11 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +16,9 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/samirettali/webmonitor/logger"
 	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PostgreStorage struct {
@@ -26,6 +33,29 @@ type PostgreStorage struct {
 
 const TIMEOUT = time.Second * 15
 
+const (
+	organisationsTable = "organisations"
+	usersTable         = "users"
+	membershipsTable   = "memberships"
+	channelsTable      = "channels"
+	checkChannelsTable = "check_channels"
+	sessionsTable      = "sessions"
+	auditTable         = "audit_log"
+	tagsTable          = "tags"
+	checkTagsTable     = "check_tags"
+	groupsTable        = "check_groups"
+	probesTable        = "probes"
+	runsTable          = "runs"
+)
+
+// ErrUnknownChannel is returned when a check references a channel that does
+// not exist in the check's organisation.
+var ErrUnknownChannel = errors.New("unknown channel")
+
+// ErrUnknownGroup is returned when a check is placed in a group that does
+// not exist in the check's organisation.
+var ErrUnknownGroup = errors.New("unknown group")
+
 func (s *PostgreStorage) Init() error {
 	var err error
 	if s.db == nil {
@@ -49,8 +79,15 @@ func (s *PostgreStorage) Init() error {
 	return nil
 }
 
+func (s *PostgreStorage) Ping(ctx context.Context) error {
+	if s.db == nil {
+		return errors.New("not connected")
+	}
+	return s.db.PingContext(ctx)
+}
+
 func (s *PostgreStorage) initTables() error {
-	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
 		id TEXT PRIMARY KEY NOT NULL,
 		name TEXT NOT NULL,
 		url TEXT NOT NULL,
@@ -58,14 +95,153 @@ func (s *PostgreStorage) initTables() error {
 		email TEXT NOT NULL,
 		active BOOLEAN NOT NULL
 	);
-	
-	CREATE TABLE IF NOT EXISTS %s (
+
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS org_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS %[1]s_org_id_idx ON %[1]s (org_id);
+
+	CREATE TABLE IF NOT EXISTS %[2]s (
 		id TEXT PRIMARY KEY NOT NULL,
-		check_id TEXT NOT NULL REFERENCES %s(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		check_id TEXT NOT NULL REFERENCES %[1]s(id) ON DELETE CASCADE ON UPDATE CASCADE,
 		content TEXT NOT NULL,
 		date TIMESTAMP NOT NULL
 	);
-	`, s.ChecksTable, s.StatusesTable, s.ChecksTable)
+
+	ALTER TABLE %[2]s ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE %[2]s ADD COLUMN IF NOT EXISTS hash TEXT NOT NULL DEFAULT '';
+	UPDATE %[2]s SET size = octet_length(content), hash = encode(sha256(convert_to(content, 'UTF8')), 'hex') WHERE hash = '';
+	CREATE INDEX IF NOT EXISTS %[2]s_check_id_date_idx ON %[2]s (check_id, date, id);
+
+	CREATE TABLE IF NOT EXISTS %[3]s (
+		id TEXT PRIMARY KEY NOT NULL,
+		name TEXT NOT NULL,
+		created TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS %[4]s (
+		id TEXT PRIMARY KEY NOT NULL,
+		email TEXT NOT NULL UNIQUE,
+		api_key_hash TEXT NOT NULL DEFAULT '',
+		created TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS %[8]s (
+		token_hash TEXT PRIMARY KEY NOT NULL,
+		user_id TEXT NOT NULL REFERENCES %[4]s(id) ON DELETE CASCADE,
+		expires TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS %[5]s (
+		org_id TEXT NOT NULL REFERENCES %[3]s(id) ON DELETE CASCADE,
+		user_id TEXT NOT NULL REFERENCES %[4]s(id) ON DELETE CASCADE,
+		role TEXT NOT NULL,
+		created TIMESTAMP NOT NULL,
+		PRIMARY KEY (org_id, user_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS %[6]s (
+		id TEXT PRIMARY KEY NOT NULL,
+		org_id TEXT NOT NULL REFERENCES %[3]s(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		target TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS %[7]s (
+		check_id TEXT NOT NULL REFERENCES %[1]s(id) ON DELETE CASCADE,
+		channel_id TEXT NOT NULL REFERENCES %[6]s(id) ON DELETE CASCADE,
+		PRIMARY KEY (check_id, channel_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS %[10]s (
+		id TEXT PRIMARY KEY NOT NULL,
+		org_id TEXT NOT NULL REFERENCES %[3]s(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		UNIQUE (org_id, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS %[11]s (
+		check_id TEXT NOT NULL REFERENCES %[1]s(id) ON DELETE CASCADE,
+		tag_id TEXT NOT NULL REFERENCES %[10]s(id) ON DELETE CASCADE,
+		PRIMARY KEY (check_id, tag_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS %[12]s (
+		id TEXT PRIMARY KEY NOT NULL,
+		org_id TEXT NOT NULL REFERENCES %[3]s(id) ON DELETE CASCADE,
+		name TEXT NOT NULL
+	);
+
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS group_id TEXT REFERENCES %[12]s(id) ON DELETE SET NULL;
+
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS key TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX IF NOT EXISTS %[1]s_org_id_key_idx ON %[1]s (org_id, key) WHERE key <> '';
+	ALTER TABLE %[6]s ADD COLUMN IF NOT EXISTS key TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX IF NOT EXISTS %[6]s_org_id_key_idx ON %[6]s (org_id, key) WHERE key <> '';
+
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}';
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS extract TEXT NOT NULL DEFAULT '';
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS ignore JSONB NOT NULL DEFAULT '[]';
+
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'content';
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS expected_status JSONB NOT NULL DEFAULT '[]';
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS max_latency BIGINT NOT NULL DEFAULT 0;
+
+	CREATE TABLE IF NOT EXISTS %[13]s (
+		id TEXT PRIMARY KEY NOT NULL,
+		check_id TEXT NOT NULL REFERENCES %[1]s(id) ON DELETE CASCADE,
+		up BOOLEAN NOT NULL,
+		status_code INTEGER NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		reason TEXT NOT NULL,
+		date TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS %[13]s_check_id_date_idx ON %[13]s (check_id, date, id);
+
+	ALTER TABLE %[2]s ADD COLUMN IF NOT EXISTS response JSONB;
+	ALTER TABLE %[13]s ADD COLUMN IF NOT EXISTS response JSONB;
+
+	CREATE TABLE IF NOT EXISTS %[14]s (
+		id TEXT PRIMARY KEY NOT NULL,
+		check_id TEXT NOT NULL REFERENCES %[1]s(id) ON DELETE CASCADE,
+		date TIMESTAMP NOT NULL,
+		outcome TEXT NOT NULL,
+		error TEXT NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		status_code INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		status_id TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS %[14]s_check_id_date_idx ON %[14]s (check_id, date, id);
+	CREATE INDEX IF NOT EXISTS %[14]s_date_idx ON %[14]s (date);
+
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS failure_threshold INTEGER NOT NULL DEFAULT 3;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS failures INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS failing_since TIMESTAMP;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS last_error_at TIMESTAMP;
+
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS timeout BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS retries INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS retry_backoff BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE %[14]s ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 1;
+
+	CREATE TABLE IF NOT EXISTS %[9]s (
+		seq BIGSERIAL PRIMARY KEY,
+		org_id TEXT NOT NULL,
+		actor_id TEXT NOT NULL,
+		actor_email TEXT NOT NULL,
+		action TEXT NOT NULL,
+		entity_type TEXT NOT NULL,
+		entity_id TEXT NOT NULL,
+		before JSONB,
+		after JSONB,
+		date TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS %[9]s_org_id_seq_idx ON %[9]s (org_id, seq DESC);
+	CREATE OR REPLACE RULE %[9]s_no_update AS ON UPDATE TO %[9]s DO INSTEAD NOTHING;
+	CREATE OR REPLACE RULE %[9]s_no_delete AS ON DELETE TO %[9]s DO INSTEAD NOTHING;
+	`, s.ChecksTable, s.StatusesTable, organisationsTable, usersTable, membershipsTable, channelsTable, checkChannelsTable, sessionsTable, auditTable,
+		tagsTable, checkTagsTable, groupsTable, probesTable, runsTable)
 
 	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
 	defer cancel()
@@ -81,15 +257,171 @@ func (s *PostgreStorage) Close() error {
 }
 
 func (s *PostgreStorage) CreateCheck(ctx context.Context, check *models.Check) error {
-	query := fmt.Sprintf("INSERT INTO %s (id, name, url, interval, email, active) VALUES(:id, :name, :url, :interval, :email, :active)", s.ChecksTable)
-	_, err := s.db.NamedExecContext(ctx, query, check)
-	return err
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkGroup(ctx, tx, check.OrgID, check.GroupID)
+	if err != nil {
+		return err
+	}
+
+	if check.Kind == "" {
+		check.Kind = models.KindContent
+	}
+
+	if check.FailureThreshold == 0 {
+		check.FailureThreshold = models.DefaultFailureThreshold
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, org_id, key, name, url, interval, email, active, group_id, headers, extract, ignore, kind, expected_status, max_latency, failure_threshold, timeout, retries, retry_backoff) VALUES(:id, :org_id, :key, :name, :url, :interval, :email, :active, :group_id, :headers, :extract, :ignore, :kind, :expected_status, :max_latency, :failure_threshold, :timeout, :retries, :retry_backoff)", s.ChecksTable)
+	_, err = tx.NamedExecContext(ctx, query, check)
+	if err != nil {
+		return duplicateError(err)
+	}
+
+	err = s.setCheckChannels(ctx, tx, check.OrgID, check.ID, check.Channels)
+	if err != nil {
+		return err
+	}
+
+	err = s.setCheckTags(ctx, tx, check.OrgID, check.ID, check.Tags)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkSorts maps the sort fields of a CheckFilter to the expression they
+// sort on and the type cursor values have to be cast to.
+var checkSorts = map[string]struct {
+	expr string
+	cast string
+}{
+	"name":         {"c.name", "text"},
+	"url":          {"c.url", "text"},
+	"interval":     {"c.interval", "bigint"},
+	"last_changed": {"COALESCE(l.last_changed, 'epoch'::timestamp)", "timestamp"},
+}
+
+// ErrInvalidSort is returned when a CheckFilter sorts on an unknown field.
+var ErrInvalidSort = errors.New("invalid sort field")
+
+// ErrNoOrganisation is returned by GetChecks when the filter has no OrgID,
+// which would select the checks of every organisation.
+var ErrNoOrganisation = errors.New("the filter has no organisation")
+
+// selectChecks returns the query selecting every check together with the
+// date of its latest status.
+func (s *PostgreStorage) selectChecks() string {
+	return fmt.Sprintf(`SELECT c.*, l.last_changed FROM %s c
+		LEFT JOIN LATERAL (SELECT max(date) AS last_changed FROM %s WHERE check_id = c.id) l ON true`, s.ChecksTable, s.StatusesTable)
+}
+
+func (s *PostgreStorage) GetChecks(ctx context.Context, filter models.CheckFilter) ([]models.Check, error) {
+	if filter.OrgID == "" {
+		return nil, ErrNoOrganisation
+	}
+	return s.getChecks(ctx, filter)
+}
+
+func (s *PostgreStorage) GetActiveChecks(ctx context.Context, interval uint64) ([]models.Check, error) {
+	active := true
+	return s.getChecks(ctx, models.CheckFilter{Active: &active, Interval: interval})
 }
 
-func (s *PostgreStorage) GetChecks(ctx context.Context) ([]models.Check, error) {
+// getChecks selects the checks matching a filter, of every organisation
+// if it has no OrgID.
+func (s *PostgreStorage) getChecks(ctx context.Context, filter models.CheckFilter) ([]models.Check, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+	}
+
+	if filter.OrgID != "" {
+		where("c.org_id = $%d", filter.OrgID)
+	}
+
+	if filter.Active != nil {
+		where("c.active = $%d", *filter.Active)
+	}
+
+	if filter.Interval != 0 {
+		where("c.interval = $%d", filter.Interval)
+	}
+
+	if filter.Failing != nil {
+		where("(c.failures > 0) = $%d", *filter.Failing)
+	}
+
+	if filter.Search != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Search) + "%"
+		where("(c.name ILIKE $%[1]d OR c.url ILIKE $%[1]d)", pattern)
+	}
+
+	if !filter.ChangedAfter.IsZero() {
+		where("l.last_changed >= $%d", filter.ChangedAfter)
+	}
+
+	if !filter.ChangedBefore.IsZero() {
+		where("l.last_changed < $%d", filter.ChangedBefore)
+	}
+
+	if filter.Tag != "" {
+		where(fmt.Sprintf("EXISTS (SELECT 1 FROM %s ct JOIN %s t ON t.id = ct.tag_id WHERE ct.check_id = c.id AND t.name = $%%d)", checkTagsTable, tagsTable), filter.Tag)
+	}
+
+	if filter.GroupID != "" {
+		where("c.group_id = $%d", filter.GroupID)
+	}
+
+	field := strings.TrimPrefix(filter.Sort, "-")
+	if field == "" {
+		field = "name"
+	}
+	sort, ok := checkSorts[field]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	order := "ASC"
+	comparison := ">"
+	if strings.HasPrefix(filter.Sort, "-") {
+		order = "DESC"
+		comparison = "<"
+	}
+
+	if filter.AfterID != "" {
+		where(fmt.Sprintf("(%s, c.id) %s ($%%d::%s, $%%d)", sort.expr, comparison, sort.cast), filter.AfterValue, filter.AfterID)
+	}
+
+	query := s.selectChecks()
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, c.id %s", sort.expr, order, order)
+
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
 	var checks []models.Check
-	query := fmt.Sprintf("SELECT * FROM %s", s.ChecksTable)
-	err := s.db.Select(&checks, query)
+	err := s.conn(ctx).SelectContext(ctx, &checks, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.loadCheckRelations(ctx, checks)
 	if err != nil {
 		return nil, err
 	}
@@ -98,14 +430,28 @@ func (s *PostgreStorage) GetChecks(ctx context.Context) ([]models.Check, error)
 }
 
 // TODO make this more efficient, use a query builder maybe
-func (s *PostgreStorage) UpdateCheck(ctx context.Context, id string, upd *models.CheckUpdate) (models.Check, error) {
+func (s *PostgreStorage) UpdateCheck(ctx context.Context, orgID string, id string, upd *models.CheckUpdate) (models.Check, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return models.Check{}, err
+	}
+	defer tx.Rollback()
+
 	var check models.Check
-	query := fmt.Sprintf("SELECT * FROM %s WHERE id=$1", s.ChecksTable)
-	err := s.db.GetContext(ctx, &check, query, id)
+	query := fmt.Sprintf("SELECT * FROM %s WHERE id=$1 AND org_id=$2", s.ChecksTable)
+	err = tx.GetContext(ctx, &check, query, id, orgID)
 	if err != nil {
 		return models.Check{}, err
 	}
 
+	if upd.Key != nil {
+		check.Key = *upd.Key
+	}
+
+	if upd.Name != nil {
+		check.Name = *upd.Name
+	}
+
 	if upd.Email != nil {
 		check.Email = *upd.Email
 	}
@@ -122,70 +468,253 @@ func (s *PostgreStorage) UpdateCheck(ctx context.Context, id string, upd *models
 		check.Active = *upd.Active
 	}
 
+	if upd.Headers != nil {
+		check.Headers = *upd.Headers
+	}
+
+	if upd.Extract != nil {
+		check.Extract = *upd.Extract
+	}
+
+	if upd.Ignore != nil {
+		check.Ignore = *upd.Ignore
+	}
+
+	if upd.Kind != nil {
+		check.Kind = *upd.Kind
+		if *upd.Kind == "" {
+			check.Kind = models.KindContent
+		}
+	}
+
+	if upd.ExpectedStatus != nil {
+		check.ExpectedStatus = *upd.ExpectedStatus
+	}
+
+	if upd.MaxLatency != nil {
+		check.MaxLatency = *upd.MaxLatency
+	}
+
+	if upd.FailureThreshold != nil {
+		check.FailureThreshold = *upd.FailureThreshold
+		if *upd.FailureThreshold == 0 {
+			check.FailureThreshold = models.DefaultFailureThreshold
+		}
+	}
+
+	if upd.Timeout != nil {
+		check.Timeout = *upd.Timeout
+	}
+
+	if upd.Retries != nil {
+		check.Retries = *upd.Retries
+	}
+
+	if upd.RetryBackoff != nil {
+		check.RetryBackoff = *upd.RetryBackoff
+	}
+
+	if upd.GroupID != nil {
+		check.GroupID = upd.GroupID
+		if *upd.GroupID == "" {
+			check.GroupID = nil
+		}
+
+		err = checkGroup(ctx, tx, orgID, check.GroupID)
+		if err != nil {
+			return models.Check{}, err
+		}
+	}
 
 	s.Logger.Infof("Updating check %s", check.ID)
 
-	statement := fmt.Sprintf("UPDATE %s SET name = :name, email = :email, interval = :interval, url = :url, active = :active WHERE id = :id", s.ChecksTable)
-	_, err = s.db.NamedExecContext(ctx, statement, &check)
+	statement := fmt.Sprintf("UPDATE %s SET key = :key, name = :name, email = :email, interval = :interval, url = :url, active = :active, group_id = :group_id, headers = :headers, extract = :extract, ignore = :ignore, kind = :kind, expected_status = :expected_status, max_latency = :max_latency, failure_threshold = :failure_threshold, timeout = :timeout, retries = :retries, retry_backoff = :retry_backoff WHERE id = :id AND org_id = :org_id", s.ChecksTable)
+	_, err = tx.NamedExecContext(ctx, statement, &check)
+	if err != nil {
+		return models.Check{}, duplicateError(err)
+	}
+
+	if upd.Channels != nil {
+		err = s.setCheckChannels(ctx, tx, orgID, check.ID, *upd.Channels)
+		if err != nil {
+			return models.Check{}, err
+		}
+	}
+
+	if upd.Tags != nil {
+		err = s.setCheckTags(ctx, tx, orgID, check.ID, *upd.Tags)
+		if err != nil {
+			return models.Check{}, err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return models.Check{}, err
 	}
 
-	return check, nil
+	return s.GetCheck(ctx, orgID, check.ID)
 }
 
-func (s *PostgreStorage) GetCheck(ctx context.Context, id string) (models.Check, error) {
+func (s *PostgreStorage) GetCheck(ctx context.Context, orgID string, id string) (models.Check, error) {
 	var check models.Check
-	query := fmt.Sprintf("SELECT * FROM %s WHERE id=$1", s.ChecksTable)
-	err := s.db.GetContext(ctx, &check, query, id)
+	query := s.selectChecks() + " WHERE c.id=$1 AND c.org_id=$2"
+	err := s.conn(ctx).GetContext(ctx, &check, query, id, orgID)
 	if err != nil {
 		return models.Check{}, err
 	}
-	return check, nil
-}
 
-func (s *PostgreStorage) GetChecksByInterval(ctx context.Context, interval uint64) ([]models.Check, error) {
-	var checks []models.Check
-	query := fmt.Sprintf("SELECT * FROM %s WHERE interval=$1 and active", s.ChecksTable)
-	err := s.db.SelectContext(ctx, &checks, query, interval)
+	checks := []models.Check{check}
+	err = s.loadCheckRelations(ctx, checks)
 	if err != nil {
-		return nil, err
+		return models.Check{}, err
 	}
-	return checks, nil
+
+	return checks[0], nil
 }
 
-func (s *PostgreStorage) DeleteCheck(ctx context.Context, id string) error {
-	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, s.ChecksTable)
-	_, err := s.db.Exec(query, id)
+func (s *PostgreStorage) DeleteCheck(ctx context.Context, orgID string, id string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1 AND org_id = $2`, s.ChecksTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, id, orgID)
 	if err != nil {
 		return err
 	}
-	return nil
+	return expectAffected(res)
+}
+
+func (s *PostgreStorage) RecordFailure(ctx context.Context, checkID string, message string, date time.Time) (int, error) {
+	ctx, span := startSpan(ctx, "RecordFailure", s.ChecksTable)
+	var failures int
+	query := fmt.Sprintf(`UPDATE %s SET failures = failures + 1, failing_since = COALESCE(failing_since, $3),
+		last_error = $2, last_error_at = $3 WHERE id = $1 RETURNING failures`, s.ChecksTable)
+	err := s.conn(ctx).QueryRowxContext(ctx, query, checkID, message, date).Scan(&failures)
+	endSpan(span, err)
+	return failures, err
+}
+
+func (s *PostgreStorage) ClearFailures(ctx context.Context, checkID string) (int, error) {
+	ctx, span := startSpan(ctx, "ClearFailures", s.ChecksTable)
+	var failures int
+	// The previous count is read from the row locked by the update, so
+	// that concurrent runs can't both see it.
+	query := fmt.Sprintf(`UPDATE %[1]s c SET failures = 0, failing_since = NULL
+		FROM (SELECT id, failures FROM %[1]s WHERE id = $1 FOR UPDATE) old
+		WHERE c.id = old.id AND old.failures > 0 RETURNING old.failures`, s.ChecksTable)
+	err := s.conn(ctx).QueryRowxContext(ctx, query, checkID).Scan(&failures)
+	endSpan(span, err)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return failures, err
 }
 
 func (s *PostgreStorage) GetStatus(ctx context.Context, checkID string) (models.Status, error) {
+	ctx, span := startSpan(ctx, "GetStatus", s.StatusesTable)
 	var status models.Status
 	query := fmt.Sprintf("SELECT * FROM %s WHERE check_id=$1 ORDER BY date DESC LIMIT 1", s.StatusesTable)
-	err := s.db.GetContext(ctx, &status, query, checkID)
+	err := s.conn(ctx).GetContext(ctx, &status, query, checkID)
+	endSpan(span, err)
 	if err != nil {
 		return models.Status{}, err
 	}
 	return status, nil
 }
 
-func (s *PostgreStorage) GetHistory(ctx context.Context, checkID string) ([]models.Status, error) {
+func (s *PostgreStorage) GetHistory(ctx context.Context, orgID string, checkID string, filter models.HistoryFilter) ([]models.Status, error) {
+	columns := "s.id, s.check_id, s.size, s.hash, s.date, s.response"
+	if filter.WithContent {
+		columns = "s.*"
+	}
+
+	conditions := []string{"s.check_id = $1", "c.org_id = $2"}
+	args := []interface{}{checkID, orgID}
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("s.date >= $%d", len(args)))
+	}
+
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("s.date < $%d", len(args)))
+	}
+
+	order := "DESC"
+	comparison := "<"
+	if filter.Ascending {
+		order = "ASC"
+		comparison = ">"
+	}
+
+	if filter.AfterID != "" {
+		args = append(args, filter.AfterDate, filter.AfterID)
+		conditions = append(conditions, fmt.Sprintf("(s.date, s.id) %s ($%d, $%d)", comparison, len(args)-1, len(args)))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s s JOIN %s c ON c.id = s.check_id WHERE %s ORDER BY s.date %s, s.id %s",
+		columns, s.StatusesTable, s.ChecksTable, strings.Join(conditions, " AND "), order, order)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
 	var statuses []models.Status
-	query := fmt.Sprintf("SELECT * FROM %s WHERE check_id=$1", s.StatusesTable)
-	err := s.db.SelectContext(ctx, &statuses, query, checkID)
+	err := s.conn(ctx).SelectContext(ctx, &statuses, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	return statuses, nil
 }
 
+func (s *PostgreStorage) GetHistoryStatus(ctx context.Context, orgID string, checkID string, id string) (models.Status, error) {
+	var status models.Status
+	query := fmt.Sprintf("SELECT s.* FROM %s s JOIN %s c ON c.id = s.check_id WHERE s.id=$1 AND s.check_id=$2 AND c.org_id=$3", s.StatusesTable, s.ChecksTable)
+	err := s.conn(ctx).GetContext(ctx, &status, query, id, checkID, orgID)
+	if err != nil {
+		return models.Status{}, err
+	}
+	return status, nil
+}
+
 func (s *PostgreStorage) UpdateStatus(ctx context.Context, checkID string, status *models.Status) error {
+	sum := sha256.Sum256([]byte(status.Content))
+	status.Size = int64(len(status.Content))
+	status.Hash = hex.EncodeToString(sum[:])
+
+	ctx, span := startSpan(ctx, "UpdateStatus", s.StatusesTable)
 	// TODO ugly, improve
-	query := fmt.Sprintf("INSERT INTO %s (id, check_id, content, date) VALUES(:id, :check_id, :content, :date)", s.StatusesTable)
-	_, err := s.db.NamedExecContext(ctx, query, status)
+	query := fmt.Sprintf("INSERT INTO %s (id, check_id, content, size, hash, date, response) VALUES(:id, :check_id, :content, :size, :hash, :date, :response)", s.StatusesTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, status)
+	endSpan(span, err)
 	return err
 }
+
+// startSpan starts the span of a call to the storage, named after the
+// method and the table it queries.
+func startSpan(ctx context.Context, method string, table string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBSQLTable(table)))
+}
+
+// endSpan ends the span of a call to the storage. A missing row is not an
+// error for the trace, callers expect it.
+func endSpan(span trace.Span, err error) {
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// expectAffected turns a statement that did not touch any row into
+// sql.ErrNoRows, so that callers can tell a missing row from a success.
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+// AddAuditEntry appends an entry to the audit log, which can't be updated
+// or deleted afterwards.
+func (s *PostgreStorage) AddAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	query := fmt.Sprintf(`INSERT INTO %s (org_id, actor_id, actor_email, action, entity_type, entity_id, before, after, date)
+		VALUES($1, $2, $3, $4, $5, $6, NULLIF($7, '')::jsonb, NULLIF($8, '')::jsonb, $9) RETURNING seq`, auditTable)
+	return s.conn(ctx).QueryRowxContext(ctx, query, entry.OrgID, entry.ActorID, entry.ActorEmail, entry.Action,
+		entry.EntityType, entry.EntityID, string(entry.Before), string(entry.After), entry.Date).Scan(&entry.Seq)
+}
+
+func (s *PostgreStorage) GetAuditLog(ctx context.Context, orgID string, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	conditions := []string{"org_id = $1"}
+	args := []interface{}{orgID}
+
+	if filter.EntityID != "" {
+		args = append(args, filter.EntityID)
+		conditions = append(conditions, fmt.Sprintf("entity_id = $%d", len(args)))
+	}
+
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		conditions = append(conditions, fmt.Sprintf("(actor_id = $%[1]d OR actor_email = $%[1]d)", len(args)))
+	}
+
+	if filter.BeforeSeq > 0 {
+		args = append(args, filter.BeforeSeq)
+		conditions = append(conditions, fmt.Sprintf("seq < $%d", len(args)))
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY seq DESC LIMIT $%d", auditTable, strings.Join(conditions, " AND "), len(args))
+
+	var entries []models.AuditEntry
+	err := s.conn(ctx).SelectContext(ctx, &entries, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/samirettali/webmonitor/models"
+)
+
+func (s *PostgreStorage) CreateChannel(ctx context.Context, channel *models.Channel) error {
+	query := fmt.Sprintf("INSERT INTO %s (id, org_id, key, name, type, target) VALUES(:id, :org_id, :key, :name, :type, :target)", channelsTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, channel)
+	return duplicateError(err)
+}
+
+func (s *PostgreStorage) GetChannel(ctx context.Context, orgID string, id string) (models.Channel, error) {
+	var channel models.Channel
+	query := fmt.Sprintf("SELECT * FROM %s WHERE id=$1 AND org_id=$2", channelsTable)
+	err := s.conn(ctx).GetContext(ctx, &channel, query, id, orgID)
+	if err != nil {
+		return models.Channel{}, err
+	}
+	return channel, nil
+}
+
+func (s *PostgreStorage) GetChannels(ctx context.Context, orgID string) ([]models.Channel, error) {
+	var channels []models.Channel
+	query := fmt.Sprintf("SELECT * FROM %s WHERE org_id=$1 ORDER BY name", channelsTable)
+	err := s.conn(ctx).SelectContext(ctx, &channels, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+func (s *PostgreStorage) UpdateChannel(ctx context.Context, orgID string, id string, upd *models.ChannelUpdate) (models.Channel, error) {
+	channel, err := s.GetChannel(ctx, orgID, id)
+	if err != nil {
+		return models.Channel{}, err
+	}
+
+	if upd.Name != nil {
+		channel.Name = *upd.Name
+	}
+
+	if upd.Target != nil {
+		channel.Target = *upd.Target
+	}
+
+	statement := fmt.Sprintf("UPDATE %s SET name = :name, target = :target WHERE id = :id AND org_id = :org_id", channelsTable)
+	_, err = s.conn(ctx).NamedExecContext(ctx, statement, &channel)
+	if err != nil {
+		return models.Channel{}, err
+	}
+
+	return channel, nil
+}
+
+func (s *PostgreStorage) DeleteChannel(ctx context.Context, orgID string, id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1 AND org_id = $2", channelsTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *PostgreStorage) GetCheckChannels(ctx context.Context, checkID string) ([]models.Channel, error) {
+	var channels []models.Channel
+	query := fmt.Sprintf("SELECT ch.* FROM %s ch JOIN %s cc ON cc.channel_id = ch.id WHERE cc.check_id=$1", channelsTable, checkChannelsTable)
+	err := s.conn(ctx).SelectContext(ctx, &channels, query, checkID)
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// setCheckChannels replaces the channels attached to a check. Every channel
+// must belong to the same organisation as the check.
+func (s *PostgreStorage) setCheckChannels(ctx context.Context, tx *txn, orgID string, checkID string, channelIDs []string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE check_id=$1", checkChannelsTable)
+	_, err := tx.ExecContext(ctx, query, checkID)
+	if err != nil {
+		return err
+	}
+
+	unique := make(map[string]struct{}, len(channelIDs))
+	for _, id := range channelIDs {
+		unique[id] = struct{}{}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	query = fmt.Sprintf("INSERT INTO %s (check_id, channel_id) SELECT $1, id FROM %s WHERE id = ANY($2) AND org_id = $3", checkChannelsTable, channelsTable)
+	res, err := tx.ExecContext(ctx, query, checkID, pq.Array(channelIDs), orgID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != int64(len(unique)) {
+		return ErrUnknownChannel
+	}
+	return nil
+}
+
+// loadCheckRelations fills the channels and the tags of every check.
+func (s *PostgreStorage) loadCheckRelations(ctx context.Context, checks []models.Check) error {
+	err := s.loadCheckChannels(ctx, checks)
+	if err != nil {
+		return err
+	}
+	return s.loadCheckTags(ctx, checks)
+}
+
+// loadCheckChannels fills the Channels field of every check with the IDs of
+// the channels attached to it.
+func (s *PostgreStorage) loadCheckChannels(ctx context.Context, checks []models.Check) error {
+	if len(checks) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(checks))
+	index := make(map[string]int, len(checks))
+	for i := range checks {
+		ids[i] = checks[i].ID
+		index[checks[i].ID] = i
+		checks[i].Channels = make([]string, 0)
+	}
+
+	var rows []struct {
+		CheckID   string `db:"check_id"`
+		ChannelID string `db:"channel_id"`
+	}
+	query := fmt.Sprintf("SELECT check_id, channel_id FROM %s WHERE check_id = ANY($1)", checkChannelsTable)
+	err := s.conn(ctx).SelectContext(ctx, &rows, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		i := index[row.CheckID]
+		checks[i].Channels = append(checks[i].Channels, row.ChannelID)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+func (s *PostgreStorage) CreateUser(ctx context.Context, user *models.User) error {
+	query := fmt.Sprintf("INSERT INTO %s (id, email, api_key_hash, created) VALUES(:id, :email, :api_key_hash, :created)", usersTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, user)
+	return err
+}
+
+func (s *PostgreStorage) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	var user models.User
+	query := fmt.Sprintf("SELECT * FROM %s WHERE email=$1", usersTable)
+	err := s.conn(ctx).GetContext(ctx, &user, query, email)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (s *PostgreStorage) GetUserByAPIKey(ctx context.Context, hash string) (models.User, error) {
+	var user models.User
+	query := fmt.Sprintf("SELECT * FROM %s WHERE api_key_hash=$1 AND api_key_hash <> ''", usersTable)
+	err := s.conn(ctx).GetContext(ctx, &user, query, hash)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (s *PostgreStorage) SetUserAPIKey(ctx context.Context, userID string, hash string) error {
+	query := fmt.Sprintf("UPDATE %s SET api_key_hash=$1 WHERE id=$2", usersTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, hash, userID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *PostgreStorage) CreateSession(ctx context.Context, session *models.Session) error {
+	query := fmt.Sprintf("INSERT INTO %s (token_hash, user_id, expires) VALUES(:token_hash, :user_id, :expires)", sessionsTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, session)
+	return err
+}
+
+func (s *PostgreStorage) GetUserBySession(ctx context.Context, hash string) (models.User, error) {
+	var user models.User
+	query := fmt.Sprintf("SELECT u.* FROM %s u JOIN %s s ON s.user_id = u.id WHERE s.token_hash=$1 AND s.expires > $2", usersTable, sessionsTable)
+	err := s.conn(ctx).GetContext(ctx, &user, query, hash, time.Now())
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (s *PostgreStorage) DeleteSession(ctx context.Context, hash string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE token_hash=$1 OR expires <= $2", sessionsTable)
+	_, err := s.conn(ctx).ExecContext(ctx, query, hash, time.Now())
+	return err
+}
+
+func (s *PostgreStorage) CreateOrganisation(ctx context.Context, org *models.Organisation, ownerID string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("INSERT INTO %s (id, name, created) VALUES(:id, :name, :created)", organisationsTable)
+	_, err = tx.NamedExecContext(ctx, query, org)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf("INSERT INTO %s (org_id, user_id, role, created) VALUES($1, $2, $3, $4)", membershipsTable)
+	_, err = tx.ExecContext(ctx, query, org.ID, ownerID, models.RoleAdmin, org.Created)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgreStorage) GetOrganisation(ctx context.Context, id string) (models.Organisation, error) {
+	var org models.Organisation
+	query := fmt.Sprintf("SELECT * FROM %s WHERE id=$1", organisationsTable)
+	err := s.conn(ctx).GetContext(ctx, &org, query, id)
+	if err != nil {
+		return models.Organisation{}, err
+	}
+	return org, nil
+}
+
+func (s *PostgreStorage) GetOrganisations(ctx context.Context, userID string) ([]models.Organisation, error) {
+	var orgs []models.Organisation
+	query := fmt.Sprintf("SELECT o.* FROM %s o JOIN %s m ON m.org_id = o.id WHERE m.user_id=$1 ORDER BY m.created", organisationsTable, membershipsTable)
+	err := s.conn(ctx).SelectContext(ctx, &orgs, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// AdoptChecks assigns every check that has no organisation, such as the
+// ones created before organisations existed, to orgID.
+func (s *PostgreStorage) AdoptChecks(ctx context.Context, orgID string) error {
+	query := fmt.Sprintf("UPDATE %s SET org_id=$1 WHERE org_id=''", s.ChecksTable)
+	_, err := s.conn(ctx).ExecContext(ctx, query, orgID)
+	return err
+}
+
+const membershipColumns = "m.org_id, m.user_id, u.email, m.role, m.created"
+
+func (s *PostgreStorage) GetMembership(ctx context.Context, orgID string, userID string) (models.Membership, error) {
+	var membership models.Membership
+	query := fmt.Sprintf("SELECT %s FROM %s m JOIN %s u ON u.id = m.user_id WHERE m.org_id=$1 AND m.user_id=$2", membershipColumns, membershipsTable, usersTable)
+	err := s.conn(ctx).GetContext(ctx, &membership, query, orgID, userID)
+	if err != nil {
+		return models.Membership{}, err
+	}
+	return membership, nil
+}
+
+func (s *PostgreStorage) GetMemberships(ctx context.Context, userID string) ([]models.Membership, error) {
+	var memberships []models.Membership
+	query := fmt.Sprintf("SELECT %s FROM %s m JOIN %s u ON u.id = m.user_id WHERE m.user_id=$1 ORDER BY m.created", membershipColumns, membershipsTable, usersTable)
+	err := s.conn(ctx).SelectContext(ctx, &memberships, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (s *PostgreStorage) GetMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	var members []models.Membership
+	query := fmt.Sprintf("SELECT %s FROM %s m JOIN %s u ON u.id = m.user_id WHERE m.org_id=$1 ORDER BY m.created", membershipColumns, membershipsTable, usersTable)
+	err := s.conn(ctx).SelectContext(ctx, &members, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (s *PostgreStorage) SetMembership(ctx context.Context, membership *models.Membership) error {
+	query := fmt.Sprintf(`INSERT INTO %s (org_id, user_id, role, created) VALUES(:org_id, :user_id, :role, :created)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role`, membershipsTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, membership)
+	return err
+}
+
+func (s *PostgreStorage) DeleteMembership(ctx context.Context, orgID string, userID string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE org_id = $1 AND user_id = $2", membershipsTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, orgID, userID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
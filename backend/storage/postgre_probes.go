@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+func (s *PostgreStorage) AddProbe(ctx context.Context, probe *models.Probe) error {
+	ctx, span := startSpan(ctx, "AddProbe", probesTable)
+	query := fmt.Sprintf("INSERT INTO %s (id, check_id, up, status_code, duration_ms, reason, date, response) VALUES(:id, :check_id, :up, :status_code, :duration_ms, :reason, :date, :response)", probesTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, probe)
+	endSpan(span, err)
+	return err
+}
+
+func (s *PostgreStorage) GetLatestProbe(ctx context.Context, checkID string) (models.Probe, error) {
+	ctx, span := startSpan(ctx, "GetLatestProbe", probesTable)
+	var probe models.Probe
+	query := fmt.Sprintf("SELECT * FROM %s WHERE check_id=$1 ORDER BY date DESC, id DESC LIMIT 1", probesTable)
+	err := s.conn(ctx).GetContext(ctx, &probe, query, checkID)
+	endSpan(span, err)
+	if err != nil {
+		return models.Probe{}, err
+	}
+	return probe, nil
+}
+
+func (s *PostgreStorage) GetProbes(ctx context.Context, orgID string, checkID string, filter models.ProbeFilter) ([]models.Probe, error) {
+	conditions := []string{"p.check_id = $1", "c.org_id = $2"}
+	args := []interface{}{checkID, orgID}
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("p.date >= $%d", len(args)))
+	}
+
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("p.date < $%d", len(args)))
+	}
+
+	if filter.AfterID != "" {
+		args = append(args, filter.AfterDate, filter.AfterID)
+		conditions = append(conditions, fmt.Sprintf("(p.date, p.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf("SELECT p.* FROM %s p JOIN %s c ON c.id = p.check_id WHERE %s ORDER BY p.date DESC, p.id DESC LIMIT $%d",
+		probesTable, s.ChecksTable, strings.Join(conditions, " AND "), len(args))
+
+	var probes []models.Probe
+	err := s.conn(ctx).SelectContext(ctx, &probes, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return probes, nil
+}
+
+func (s *PostgreStorage) GetUptime(ctx context.Context, orgID string, checkID string, since time.Time) (models.Uptime, error) {
+	var uptime models.Uptime
+	query := fmt.Sprintf(`SELECT count(*) AS probes, count(*) FILTER (WHERE p.up) AS up FROM %s p
+		JOIN %s c ON c.id = p.check_id WHERE p.check_id = $1 AND c.org_id = $2 AND p.date >= $3`, probesTable, s.ChecksTable)
+	err := s.conn(ctx).GetContext(ctx, &uptime, query, checkID, orgID, since)
+	if err != nil {
+		return models.Uptime{}, err
+	}
+	return uptime, nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samirettali/webmonitor/models"
+)
+
+func (s *PostgreStorage) AddRun(ctx context.Context, run *models.Run) error {
+	ctx, span := startSpan(ctx, "AddRun", runsTable)
+	query := fmt.Sprintf(`INSERT INTO %s (id, check_id, date, outcome, error, duration_ms, status_code, attempts, hash, status_id)
+		VALUES(:id, :check_id, :date, :outcome, :error, :duration_ms, :status_code, :attempts, :hash, :status_id)`, runsTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, run)
+	endSpan(span, err)
+	return err
+}
+
+func (s *PostgreStorage) GetRuns(ctx context.Context, orgID string, checkID string, filter models.RunFilter) ([]models.Run, error) {
+	conditions := []string{"r.check_id = $1", "c.org_id = $2"}
+	args := []interface{}{checkID, orgID}
+
+	if filter.Outcome != "" {
+		args = append(args, filter.Outcome)
+		conditions = append(conditions, fmt.Sprintf("r.outcome = $%d", len(args)))
+	}
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("r.date >= $%d", len(args)))
+	}
+
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("r.date < $%d", len(args)))
+	}
+
+	if filter.AfterID != "" {
+		args = append(args, filter.AfterDate, filter.AfterID)
+		conditions = append(conditions, fmt.Sprintf("(r.date, r.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf("SELECT r.* FROM %s r JOIN %s c ON c.id = r.check_id WHERE %s ORDER BY r.date DESC, r.id DESC LIMIT $%d",
+		runsTable, s.ChecksTable, strings.Join(conditions, " AND "), len(args))
+
+	var runs []models.Run
+	err := s.conn(ctx).SelectContext(ctx, &runs, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (s *PostgreStorage) DeleteRuns(ctx context.Context, before time.Time) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE date < $1", runsTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/samirettali/webmonitor/models"
+)
+
+// ErrDuplicate is returned when a tag is given a name, or a check or a
+// channel a key, that is already taken in its organisation.
+var ErrDuplicate = errors.New("name already in use")
+
+func duplicateError(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *PostgreStorage) CreateTag(ctx context.Context, tag *models.Tag) error {
+	query := fmt.Sprintf("INSERT INTO %s (id, org_id, name) VALUES(:id, :org_id, :name)", tagsTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, tag)
+	return duplicateError(err)
+}
+
+func (s *PostgreStorage) selectTags() string {
+	return fmt.Sprintf("SELECT t.*, count(ct.check_id) AS checks FROM %s t LEFT JOIN %s ct ON ct.tag_id = t.id", tagsTable, checkTagsTable)
+}
+
+func (s *PostgreStorage) GetTag(ctx context.Context, orgID string, id string) (models.Tag, error) {
+	var tag models.Tag
+	query := s.selectTags() + " WHERE t.id=$1 AND t.org_id=$2 GROUP BY t.id"
+	err := s.conn(ctx).GetContext(ctx, &tag, query, id, orgID)
+	if err != nil {
+		return models.Tag{}, err
+	}
+	return tag, nil
+}
+
+func (s *PostgreStorage) GetTags(ctx context.Context, orgID string) ([]models.Tag, error) {
+	var tags []models.Tag
+	query := s.selectTags() + " WHERE t.org_id=$1 GROUP BY t.id ORDER BY t.name"
+	err := s.conn(ctx).SelectContext(ctx, &tags, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *PostgreStorage) RenameTag(ctx context.Context, orgID string, id string, name string) (models.Tag, error) {
+	query := fmt.Sprintf("UPDATE %s SET name=$1 WHERE id=$2 AND org_id=$3", tagsTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, name, id, orgID)
+	if err != nil {
+		return models.Tag{}, duplicateError(err)
+	}
+	if err = expectAffected(res); err != nil {
+		return models.Tag{}, err
+	}
+	return s.GetTag(ctx, orgID, id)
+}
+
+func (s *PostgreStorage) DeleteTag(ctx context.Context, orgID string, id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1 AND org_id = $2", tagsTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *PostgreStorage) CreateGroup(ctx context.Context, group *models.Group) error {
+	query := fmt.Sprintf("INSERT INTO %s (id, org_id, name) VALUES(:id, :org_id, :name)", groupsTable)
+	_, err := s.conn(ctx).NamedExecContext(ctx, query, group)
+	return err
+}
+
+func (s *PostgreStorage) selectGroups() string {
+	return fmt.Sprintf("SELECT g.*, count(c.id) AS checks FROM %s g LEFT JOIN %s c ON c.group_id = g.id", groupsTable, s.ChecksTable)
+}
+
+func (s *PostgreStorage) GetGroup(ctx context.Context, orgID string, id string) (models.Group, error) {
+	var group models.Group
+	query := s.selectGroups() + " WHERE g.id=$1 AND g.org_id=$2 GROUP BY g.id"
+	err := s.conn(ctx).GetContext(ctx, &group, query, id, orgID)
+	if err != nil {
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+func (s *PostgreStorage) GetGroups(ctx context.Context, orgID string) ([]models.Group, error) {
+	var groups []models.Group
+	query := s.selectGroups() + " WHERE g.org_id=$1 GROUP BY g.id ORDER BY g.name"
+	err := s.conn(ctx).SelectContext(ctx, &groups, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (s *PostgreStorage) RenameGroup(ctx context.Context, orgID string, id string, name string) (models.Group, error) {
+	query := fmt.Sprintf("UPDATE %s SET name=$1 WHERE id=$2 AND org_id=$3", groupsTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, name, id, orgID)
+	if err != nil {
+		return models.Group{}, err
+	}
+	if err = expectAffected(res); err != nil {
+		return models.Group{}, err
+	}
+	return s.GetGroup(ctx, orgID, id)
+}
+
+// DeleteGroup deletes a group, leaving the checks it contained without one.
+func (s *PostgreStorage) DeleteGroup(ctx context.Context, orgID string, id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1 AND org_id = $2", groupsTable)
+	res, err := s.conn(ctx).ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// checkGroup makes sure that groupID, if any, belongs to the organisation.
+func checkGroup(ctx context.Context, tx *txn, orgID string, groupID *string) error {
+	if groupID == nil {
+		return nil
+	}
+
+	var id string
+	query := fmt.Sprintf("SELECT id FROM %s WHERE id=$1 AND org_id=$2", groupsTable)
+	err := tx.GetContext(ctx, &id, query, *groupID, orgID)
+	if err == sql.ErrNoRows {
+		return ErrUnknownGroup
+	}
+	return err
+}
+
+// setCheckTags replaces the tags of a check, creating the ones that don't
+// exist yet.
+func (s *PostgreStorage) setCheckTags(ctx context.Context, tx *txn, orgID string, checkID string, names []string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE check_id=$1", checkTagsTable)
+	_, err := tx.ExecContext(ctx, query, checkID)
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	query = fmt.Sprintf("INSERT INTO %s (id, org_id, name) VALUES($1, $2, $3) ON CONFLICT (org_id, name) DO NOTHING", tagsTable)
+	for _, name := range names {
+		_, err = tx.ExecContext(ctx, query, uuid.NewString(), orgID, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	query = fmt.Sprintf("INSERT INTO %s (check_id, tag_id) SELECT $1, id FROM %s WHERE org_id = $2 AND name = ANY($3)", checkTagsTable, tagsTable)
+	_, err = tx.ExecContext(ctx, query, checkID, orgID, pq.Array(names))
+	return err
+}
+
+// loadCheckTags fills the Tags field of every check with the sorted names of
+// its tags.
+func (s *PostgreStorage) loadCheckTags(ctx context.Context, checks []models.Check) error {
+	if len(checks) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(checks))
+	index := make(map[string]int, len(checks))
+	for i := range checks {
+		ids[i] = checks[i].ID
+		index[checks[i].ID] = i
+		checks[i].Tags = make([]string, 0)
+	}
+
+	var rows []struct {
+		CheckID string `db:"check_id"`
+		Name    string `db:"name"`
+	}
+	query := fmt.Sprintf("SELECT ct.check_id, t.name FROM %s ct JOIN %s t ON t.id = ct.tag_id WHERE ct.check_id = ANY($1)", checkTagsTable, tagsTable)
+	err := s.conn(ctx).SelectContext(ctx, &rows, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		i := index[row.CheckID]
+		checks[i].Tags = append(checks[i].Tags, row.Name)
+	}
+
+	for i := range checks {
+		sort.Strings(checks[i].Tags)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// stubDriver is a database driver whose statements succeed without
+// returning any row, except the ones containing "fail", which fail.
+type stubDriver struct{}
+
+func (stubDriver) Open(name string) (driver.Conn, error) { return stubConn{}, nil }
+
+type stubConn struct{}
+
+func (stubConn) Prepare(query string) (driver.Stmt, error) { return stubStmt{query}, nil }
+func (stubConn) Close() error                              { return nil }
+func (stubConn) Begin() (driver.Tx, error)                 { return nil, errors.New("no transactions") }
+
+type stubStmt struct{ query string }
+
+func (s stubStmt) Close() error  { return nil }
+func (s stubStmt) NumInput() int { return -1 }
+
+func (s stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "fail") {
+		return nil, errors.New("stub failure")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "fail") {
+		return nil, errors.New("stub failure")
+	}
+	return stubRows{}, nil
+}
+
+type stubRows struct{}
+
+func (stubRows) Columns() []string              { return []string{"id"} }
+func (stubRows) Close() error                   { return nil }
+func (stubRows) Next(dest []driver.Value) error { return io.EOF }
+
+func init() {
+	sql.Register("stub", stubDriver{})
+}
+
+func TestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), 1)
+	previous := otel.GetTracerProvider()
+	tracing.Install(provider)
+	defer tracing.Install(previous)
+
+	db, err := sqlx.Open("stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &PostgreStorage{ChecksTable: "checks", StatusesTable: "statuses", db: db}
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "check.run")
+	err = s.UpdateStatus(ctx, "c1", &models.Status{ID: "s1", CheckID: "c1", Content: "content", Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetStatus(ctx, "c1")
+	if err != sql.ErrNoRows {
+		t.Fatalf("GetStatus returned %v, want sql.ErrNoRows", err)
+	}
+	s.ChecksTable = "fail"
+	_, err = s.RecordFailure(ctx, "c1", "timeout", time.Now())
+	if err == nil {
+		t.Fatal("RecordFailure didn't fail")
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("%d spans, want 4", len(spans))
+	}
+	want := []struct {
+		name   string
+		table  string
+		status codes.Code
+	}{
+		{"storage.UpdateStatus", "statuses", codes.Unset},
+		// A missing row is expected by the callers.
+		{"storage.GetStatus", "statuses", codes.Unset},
+		{"storage.RecordFailure", "fail", codes.Error},
+	}
+	for i, w := range want {
+		span := spans[i]
+		if span.Name != w.name {
+			t.Errorf("span %d is %s, want %s", i, span.Name, w.name)
+			continue
+		}
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the span of the caller", span.Name)
+		}
+		if span.Status.Code != w.status {
+			t.Errorf("%s has status %v, want %v", span.Name, span.Status.Code, w.status)
+		}
+		attrs := make(map[string]string)
+		for _, attr := range span.Attributes {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+		if attrs[string(semconv.DBSystemKey)] != "postgresql" || attrs[string(semconv.DBSQLTableKey)] != w.table {
+			t.Errorf("%s has attributes %v", span.Name, attrs)
+		}
+	}
+}
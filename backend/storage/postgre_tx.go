@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type txKey struct{}
+
+// queryer runs queries on the database or in a transaction.
+type queryer interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+// txn is a transaction started by a method, or the one of Atomic that the
+// method joined, which only Atomic commits or rolls back.
+type txn struct {
+	*sqlx.Tx
+	joined bool
+}
+
+func (t *txn) Commit() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t *txn) Rollback() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
+
+// Atomic runs fn in a transaction, committed if fn returns nil and rolled
+// back otherwise. The methods called with the context passed to fn run in
+// the transaction, and once one of them fails the others do too, so fn
+// should return its first error.
+func (s *PostgreStorage) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// conn returns the transaction of Atomic the context carries, if any, or
+// the database.
+func (s *PostgreStorage) conn(ctx context.Context) queryer {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+// begin starts a transaction, or joins the one of Atomic the context
+// carries.
+func (s *PostgreStorage) begin(ctx context.Context) (*txn, error) {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return &txn{Tx: tx, joined: true}, nil
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx}, nil
+}
@@ -2,21 +2,93 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/samirettali/webmonitor/models"
 )
 
+// Storage persists checks and their statuses. Methods that take an orgID,
+// or a filter with one, only ever read or modify rows owned by that
+// organisation; the remaining ones are meant for internal use by the
+// monitor.
 type Storage interface {
 	Init() error
 	Close() error
+	// Ping tells whether the database can be reached.
+	Ping(ctx context.Context) error
+	// Atomic runs fn in a transaction that the methods called with the
+	// context it receives take part in. It is committed if fn returns nil.
+	Atomic(ctx context.Context, fn func(ctx context.Context) error) error
 	CreateCheck(ctx context.Context, check *models.Check) error
-	GetCheck(ctx context.Context, id string) (models.Check, error)
-	GetChecks(ctx context.Context) ([]models.Check, error)
-	UpdateCheck(ctx context.Context, id string, upd *models.CheckUpdate) (models.Check, error)
-	DeleteCheck(ctx context.Context, id string) error
+	GetCheck(ctx context.Context, orgID string, id string) (models.Check, error)
+	// GetChecks requires the OrgID of the filter, see ErrNoOrganisation.
+	GetChecks(ctx context.Context, filter models.CheckFilter) ([]models.Check, error)
+	// GetActiveChecks returns the active checks of an interval of every
+	// organisation, for the monitor to run them.
+	GetActiveChecks(ctx context.Context, interval uint64) ([]models.Check, error)
+	UpdateCheck(ctx context.Context, orgID string, id string, upd *models.CheckUpdate) (models.Check, error)
+	// RecordFailure counts a failed run of a check and returns the number
+	// of consecutive ones.
+	RecordFailure(ctx context.Context, checkID string, message string, date time.Time) (int, error)
+	// ClearFailures resets the consecutive failures of a check and returns
+	// how many there were.
+	ClearFailures(ctx context.Context, checkID string) (int, error)
+	DeleteCheck(ctx context.Context, orgID string, id string) error
 	GetStatus(ctx context.Context, checkID string) (models.Status, error)
-	GetHistory(ctx context.Context, checkID string) ([]models.Status, error)
+	GetHistory(ctx context.Context, orgID string, checkID string, filter models.HistoryFilter) ([]models.Status, error)
+	GetHistoryStatus(ctx context.Context, orgID string, checkID string, id string) (models.Status, error)
 	UpdateStatus(ctx context.Context, checkID string, status *models.Status) error
-	// TODO implement get method using a filter struct
-	GetChecksByInterval(ctx context.Context, interval uint64) ([]models.Check, error)
+
+	AddProbe(ctx context.Context, probe *models.Probe) error
+	// GetLatestProbe returns sql.ErrNoRows if the check was never probed.
+	GetLatestProbe(ctx context.Context, checkID string) (models.Probe, error)
+	GetProbes(ctx context.Context, orgID string, checkID string, filter models.ProbeFilter) ([]models.Probe, error)
+	// GetUptime counts the probes of a check made since a date.
+	GetUptime(ctx context.Context, orgID string, checkID string, since time.Time) (models.Uptime, error)
+
+	AddRun(ctx context.Context, run *models.Run) error
+	GetRuns(ctx context.Context, orgID string, checkID string, filter models.RunFilter) ([]models.Run, error)
+	// DeleteRuns deletes the runs of every check made before a date and
+	// returns how many there were.
+	DeleteRuns(ctx context.Context, before time.Time) (int64, error)
+
+	CreateChannel(ctx context.Context, channel *models.Channel) error
+	GetChannel(ctx context.Context, orgID string, id string) (models.Channel, error)
+	GetChannels(ctx context.Context, orgID string) ([]models.Channel, error)
+	UpdateChannel(ctx context.Context, orgID string, id string, upd *models.ChannelUpdate) (models.Channel, error)
+	DeleteChannel(ctx context.Context, orgID string, id string) error
+	GetCheckChannels(ctx context.Context, checkID string) ([]models.Channel, error)
+
+	CreateTag(ctx context.Context, tag *models.Tag) error
+	GetTag(ctx context.Context, orgID string, id string) (models.Tag, error)
+	GetTags(ctx context.Context, orgID string) ([]models.Tag, error)
+	RenameTag(ctx context.Context, orgID string, id string, name string) (models.Tag, error)
+	DeleteTag(ctx context.Context, orgID string, id string) error
+
+	CreateGroup(ctx context.Context, group *models.Group) error
+	GetGroup(ctx context.Context, orgID string, id string) (models.Group, error)
+	GetGroups(ctx context.Context, orgID string) ([]models.Group, error)
+	RenameGroup(ctx context.Context, orgID string, id string, name string) (models.Group, error)
+	DeleteGroup(ctx context.Context, orgID string, id string) error
+
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	GetUserByAPIKey(ctx context.Context, hash string) (models.User, error)
+	SetUserAPIKey(ctx context.Context, userID string, hash string) error
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetUserBySession(ctx context.Context, hash string) (models.User, error)
+	DeleteSession(ctx context.Context, hash string) error
+
+	CreateOrganisation(ctx context.Context, org *models.Organisation, ownerID string) error
+	GetOrganisation(ctx context.Context, id string) (models.Organisation, error)
+	GetOrganisations(ctx context.Context, userID string) ([]models.Organisation, error)
+	AdoptChecks(ctx context.Context, orgID string) error
+	GetMembership(ctx context.Context, orgID string, userID string) (models.Membership, error)
+	GetMemberships(ctx context.Context, userID string) ([]models.Membership, error)
+	GetMembers(ctx context.Context, orgID string) ([]models.Membership, error)
+	SetMembership(ctx context.Context, membership *models.Membership) error
+	DeleteMembership(ctx context.Context, orgID string, userID string) error
+
+	AddAuditEntry(ctx context.Context, entry *models.AuditEntry) error
+	GetAuditLog(ctx context.Context, orgID string, filter models.AuditFilter) ([]models.AuditEntry, error)
 }
@@ -0,0 +1,69 @@
+// Package tracing sets up the OpenTelemetry traces of the server, made of a
+// trace per check run and per API request.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/samirettali/webmonitor"
+
+// Tracer returns the tracer of the server. Until Setup or Install is called
+// its spans are discarded.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Setup exports the spans of a fraction of the traces, between 0 and 1, to
+// an OTLP collector listening for HTTP requests at endpoint, a host and
+// port. The returned function flushes the pending spans.
+func Setup(ctx context.Context, endpoint string, insecure bool, ratio float64) (func(context.Context) error, error) {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewProvider(sdktrace.NewBatchSpanProcessor(exporter), ratio)
+	Install(provider)
+	return provider.Shutdown, nil
+}
+
+// NewProvider returns a provider sending the spans of a fraction of the
+// traces to processor. Traces started by a remote caller follow its
+// decision.
+func NewProvider(processor sdktrace.SpanProcessor, ratio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("webmonitor"))),
+	)
+}
+
+// Install makes provider the one of Tracer and propagates the traces of the
+// callers with the W3C headers.
+func Install(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// End ends a span, recording err if not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
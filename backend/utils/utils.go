@@ -1,37 +1,292 @@
 package utils
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/samirettali/webmonitor/models"
+	"github.com/samirettali/webmonitor/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; rv:68.0) Gecko/20100101 Firefox/68.0"
 
+// Response is the outcome of a request made by Fetch.
+type Response struct {
+	Body        string
+	StatusCode  int
+	ContentType string
+	// Duration is the time between sending the request and reading the
+	// whole body.
+	Duration time.Duration
+	// Info describes the response. Fetch returns it along with errors
+	// too, with the timing of what happened before the failure.
+	Info *models.Response
+	// Attempts is the number of requests made, including the retries.
+	Attempts int
+}
+
+// DefaultTimeout and DefaultBackoff are the timeout and the backoff of
+// the policies that don't set them.
+const (
+	DefaultTimeout = 10 * time.Second
+	DefaultBackoff = time.Second
+)
+
+// Policy says how long the requests made by Fetch can take and how many
+// times they are retried.
+type Policy struct {
+	// Timeout bounds every attempt, DefaultTimeout when zero.
+	Timeout time.Duration
+	// Retries is the number of times a request is made again after a
+	// retryable failure.
+	Retries int
+	// Backoff is the wait before the first retry, DefaultBackoff when
+	// zero. It doubles before each following retry.
+	Backoff time.Duration
+}
+
+func (p Policy) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return DefaultTimeout
+}
+
+// backoff returns the wait before the retry following an attempt,
+// counted from 0.
+func (p Policy) backoff(attempt int) time.Duration {
+	backoff := p.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	return backoff << attempt
+}
+
+// Budget returns the longest time Fetch can take with the policy, when
+// every attempt times out.
+func (p Policy) Budget() time.Duration {
+	budget := time.Duration(p.Retries+1) * p.timeout()
+	for attempt := 0; attempt < p.Retries; attempt++ {
+		budget += p.backoff(attempt)
+	}
+	return budget
+}
+
 func Request(URL string) (string, error) {
+	resp, err := Fetch(context.Background(), URL, nil, Policy{})
+	if err != nil {
+		return "", err
+	}
+	return resp.Body, nil
+}
+
+// Fetch requests a page with additional headers and reads its body. The
+// request is made again as the policy allows when it fails in a way that
+// may not last, see retryable, and the outcome of the last attempt is
+// returned.
+func Fetch(ctx context.Context, URL string, headers map[string]string, policy Policy) (Response, error) {
 	client := &http.Client{
-		Timeout: time.Second * 10,
+		Timeout: policy.timeout(),
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := fetch(ctx, client, URL, headers, attempt)
+		resp.Attempts = attempt + 1
+		if attempt == policy.Retries || !retryable(resp.StatusCode, err) {
+			return resp, err
+		}
+
+		wait := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-wait.C:
+		case <-ctx.Done():
+			wait.Stop()
+			return resp, err
+		}
+	}
+}
+
+// StatusError is the failure of a page that answers with a server error,
+// which Fetch retries but doesn't return as an error itself.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server answered with status %d", e.Code)
+}
+
+// retryable tells whether a request may succeed if made again, because it
+// timed out, the connection was reset or closed, or the server answered
+// with a 5xx status.
+func retryable(statusCode int, err error) bool {
+	if err == nil {
+		return statusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// fetch makes an attempt of Fetch, attempt being the number of the
+// previous ones.
+func fetch(ctx context.Context, client *http.Client, URL string, headers map[string]string, attempt int) (resp Response, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "HTTP GET",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodGet, semconv.URLFull(URL)))
+	if attempt > 0 {
+		span.SetAttributes(semconv.HTTPRequestResendCount(attempt))
 	}
+	defer func() {
+		if err == nil {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode), semconv.HTTPResponseBodySize(len(resp.Body)))
+		}
+		tracing.End(span, err)
+	}()
 
 	// log.Println(fmt.Sprintf("Requesting %s", URL))
-	req, err := http.NewRequest("GET", URL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
 	if err != nil {
-		return "", err
+		return Response{}, err
 	}
 
 	req.Header.Set("User-Agent", USER_AGENT)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	watch := &stopwatch{start: time.Now()}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, watch.trace()))
+	info := &models.Response{URL: URL}
+	defer func() { info.Timing = watch.timing() }()
 
 	response, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return Response{Info: info}, err
 	}
 
 	defer response.Body.Close()
 
+	info.StatusCode = response.StatusCode
+	info.URL = response.Request.URL.String()
+	info.ContentType = response.Header.Get("Content-Type")
+	info.Headers = responseHeaders(response.Header)
+	if response.TLS != nil {
+		info.TLSVersion = tls.VersionName(response.TLS.Version)
+	}
+
 	body, err := ioutil.ReadAll(response.Body)
+	duration := watch.stop()
 	if err != nil {
-		return "", nil
+		return Response{Info: info}, err
+	}
+	info.ContentLength = int64(len(body))
+
+	return Response{
+		Body:        string(body),
+		StatusCode:  response.StatusCode,
+		ContentType: response.Header.Get("Content-Type"),
+		Duration:    duration,
+		Info:        info,
+	}, nil
+}
+
+// responseHeaders flattens the headers of a response, leaving out the
+// cookies, which may hold the session of the monitoring account.
+func responseHeaders(header http.Header) models.Headers {
+	headers := make(models.Headers, len(header))
+	for name, values := range header {
+		if name == "Set-Cookie" {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+// stopwatch records the timing of a request through httptrace. The hooks
+// may be called from other goroutines than the one making the request.
+type stopwatch struct {
+	mu        sync.Mutex
+	start     time.Time
+	end       time.Time
+	dnsStart  time.Time
+	connStart time.Time
+	tlsStart  time.Time
+	firstByte time.Time
+	dns       time.Duration
+	connect   time.Duration
+	tls       time.Duration
+}
+
+func (t *stopwatch) trace() *httptrace.ClientTrace {
+	// since adds the time elapsed since a start to a total, the hooks of
+	// every redirect add up.
+	since := func(start *time.Time, total *time.Duration) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if !start.IsZero() {
+			*total += time.Since(*start)
+			*start = time.Time{}
+		}
+	}
+	mark := func(at *time.Time) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		*at = time.Now()
 	}
 
-	return string(body), nil
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { mark(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { since(&t.dnsStart, &t.dns) },
+		ConnectStart:         func(string, string) { mark(&t.connStart) },
+		ConnectDone:          func(string, string, error) { since(&t.connStart, &t.connect) },
+		TLSHandshakeStart:    func() { mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { since(&t.tlsStart, &t.tls) },
+		GotFirstResponseByte: func() { mark(&t.firstByte) },
+	}
+}
+
+// stop records the end of the request and returns its duration.
+func (t *stopwatch) stop() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.end = time.Now()
+	return t.end.Sub(t.start)
+}
+
+func (t *stopwatch) timing() models.Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	end := t.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	timing := models.Timing{
+		DNSMS:     t.dns.Milliseconds(),
+		ConnectMS: t.connect.Milliseconds(),
+		TLSMS:     t.tls.Milliseconds(),
+		TotalMS:   end.Sub(t.start).Milliseconds(),
+	}
+	if !t.firstByte.IsZero() {
+		timing.TTFBMS = t.firstByte.Sub(t.start).Milliseconds()
+	}
+	return timing
 }
@@ -9,7 +9,7 @@ import {
   Status,
 } from "../model";
 
-import { BACKEND_URL } from "../constants";
+import { API_KEY, BACKEND_URL } from "../constants";
 import { isArray } from "util";
 
 const dateFormat = /^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z$/;
@@ -17,6 +17,7 @@ const dateFormat = /^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z$/;
 const instance = axios.create({
   baseURL: BACKEND_URL,
   timeout: 1000,
+  headers: API_KEY ? { Authorization: `Bearer ${API_KEY}` } : {},
 });
 
 instance.interceptors.response.use(
@@ -1,4 +1,5 @@
-export const BACKEND_URL = "http://localhost:8000";
+export const BACKEND_URL = "http://localhost:8000/api/v1";
+export const API_KEY = process.env.REACT_APP_API_KEY;
 export const QUERY_KEY = "checks";
 export const HISTORY_QUERY_KEY = "history";
 export const INTERVALS = [1, 3, 15, 60, 300, 600];